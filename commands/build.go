@@ -2,12 +2,15 @@ package commands
 
 import (
 	. "fmt"
+	. "polydawn.net/hroot/util"
 )
 
 type BuildCmdOpts struct {
 	DockerH     string `short:"H"                    description:"Where to connect to docker daemon."`
 	Source      string `short:"s" long:"source"      description:"Container source.      (default: graph)"`
 	Destination string `short:"d" long:"destination" description:"Container destination. (default: graph)"`
+	Profile     string `long:"profile"               description:"Configuration profile to use. (default: $HROOT_PROFILE)"`
+	OverrideOpts
 	NoOp        bool   `long:"noop" description:"Set the container command to /bin/true."`
 	Epoch       bool   `long:"epoch" description:"Force all file modtimes to epoch."`
 }
@@ -16,11 +19,19 @@ const DefaultBuildTarget = "build"
 
 //Transforms a container
 func (opts *BuildCmdOpts) Execute(args []string) error {
-	//Load settings
-	hroot := LoadHroot(args, DefaultBuildTarget, opts.Source, opts.Destination)
+	//Anything after -- goes on the end of the command
+	args, extra := splitPassthrough(args)
+	if len(args) > 1 {
+		ExitGently("Usage: hroot build [target] [-- arguments...]")
+	}
+
+	//Load settings, and apply any overrides
+	hroot := LoadHroot(args, DefaultBuildTarget, opts.Profile, opts.Source, opts.Destination)
+	opts.Apply(&hroot.settings, extra)
 
-	//We're building; launch upstream image
+	//We're building; launch upstream image, with any files it takes from other images
 	hroot.launchImage = hroot.image.Upstream
+	hroot.stages = hroot.image.From
 	Println("Building from", hroot.image.Upstream, "to", hroot.image.Name)
 
 	//If desired, set the command to /bin/true and do not modify destination image name
@@ -28,6 +39,7 @@ func (opts *BuildCmdOpts) Execute(args []string) error {
 	// 'docker export ubuntu' --> 'Error: No such container: ubuntu' --> :(
 	if opts.NoOp {
 		hroot.settings.Command = []string{ "/bin/true" }
+		hroot.settings.Steps = nil
 	}
 
 	//Prepare source & destination
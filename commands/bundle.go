@@ -0,0 +1,37 @@
+package commands
+
+import (
+	. "polydawn.net/hroot/util"
+)
+
+//Groups the bundle subcommands; does nothing on its own
+type BundleCmdOpts struct { }
+
+type BundleCreateCmdOpts struct {
+	Base        string `long:"base" description:"Leave out everything this version already has."`
+}
+
+//Writes images from the graph to a bundle file
+func (opts *BundleCreateCmdOpts) Execute(args []string) error {
+	if len(args) < 1 {
+		ExitGently("Usage: hroot bundle create <file> [image...] [--base hash]")
+	}
+
+	graph := OpenGraph()
+	graph.Bundle(SanePath(args[0]), opts.Base, args[1:]...)
+	return nil
+}
+
+type BundleLoadCmdOpts struct { }
+
+//Loads images from a bundle file into the graph
+func (opts *BundleLoadCmdOpts) Execute(args []string) error {
+	if len(args) != 1 {
+		ExitGently("Usage: hroot bundle load <file>")
+	}
+
+	//Open the graph, making one if needed
+	graph := CreateGraph()
+	graph.LoadBundle(SanePath(args[0]))
+	return nil
+}
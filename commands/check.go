@@ -0,0 +1,42 @@
+package commands
+
+import (
+	. "fmt"
+	"os"
+	"polydawn.net/hroot/conf"
+	. "polydawn.net/hroot/util"
+)
+
+type CheckCmdOpts struct { }
+
+//Exit code when configuration has problems that would stop hroot (clear of the verify command's codes)
+const ExitCheckFailed = 32
+
+//Checks every config file that applies to the current directory, without touching docker
+func (opts *CheckCmdOpts) Execute(args []string) error {
+	if len(args) != 0 {
+		ExitGently("Usage: hroot check")
+	}
+
+	problems := conf.CheckConfigurationOnDisk(".", configFile, &conf.FileConfigParser{})
+	fatal := 0
+	for _, problem := range problems {
+		if problem.Fatal {
+			fatal++
+			Println("Error:", problem)
+		} else {
+			Println("Warning:", problem)
+		}
+	}
+
+	if fatal > 0 {
+		Println(fatal, "errors,", len(problems) - fatal, "warnings.")
+		os.Exit(ExitCheckFailed)
+	}
+	if len(problems) > 0 {
+		Println("Configuration is usable, with", len(problems), "warnings.")
+	} else {
+		Println("Configuration OK.")
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package commands
+
+import (
+	. "fmt"
+	"io/ioutil"
+	"os"
+	"polydawn.net/hroot/dex"
+	. "polydawn.net/hroot/util"
+)
+
+type CheckoutCmdOpts struct { }
+
+//Checks out an image from the graph into a host directory, without involving docker
+func (opts *CheckoutCmdOpts) Execute(args []string) error {
+	if len(args) != 2 {
+		ExitGently("Usage: hroot checkout <image>[@hash] <dir>")
+	}
+	image, version := SplitImageHash(args[0])
+	dir := SanePath(args[1])
+
+	//Refuse to mix an image into existing files
+	files, err := ioutil.ReadDir(dir)
+	if err == nil && len(files) > 0 {
+		ExitGently("Directory", dir, "is not empty.")
+	} else if err != nil && !os.IsNotExist(err) {
+		ExitGently(err)
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil { ExitGently(err) }
+
+	//Open the graph
+	graph := OpenGraph()
+
+	Println("Checking out", image, "to", dir)
+	hash := graph.LoadVersion(
+		image,
+		version,
+		&dex.GraphLoadRequest_Dir{
+			Path: dir,
+		},
+	)
+
+	Println("Checked out", image + "@" + hash)
+	return nil
+}
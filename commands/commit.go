@@ -0,0 +1,53 @@
+package commands
+
+import (
+	. "fmt"
+	"os"
+	"polydawn.net/hroot/dex"
+	. "polydawn.net/hroot/util"
+	guitarconf "polydawn.net/guitar/conf"
+)
+
+type CommitCmdOpts struct {
+	Epoch       bool   `long:"epoch" description:"Force all file modtimes to epoch."`
+}
+
+//Publishes a host directory into the graph, without involving docker
+func (opts *CommitCmdOpts) Execute(args []string) error {
+	if len(args) != 2 {
+		ExitGently("Usage: hroot commit <dir> <image>")
+	}
+	dir := SanePath(args[0])
+	image := args[1]
+
+	stat, err := os.Stat(dir)
+	if err != nil { ExitGently(err) }
+	if !stat.IsDir() {
+		ExitGently(dir, "is not a directory.")
+	}
+
+	//Open the graph, making one if needed
+	graph := CreateGraph()
+	RequireGitConfig(graph)
+
+	//If the image already exists, this is the next version of it
+	ancestor := ""
+	if graph.HasLineage(image) {
+		ancestor = image
+	}
+
+	Println("Committing", dir, "to", image)
+	hash := graph.Publish(
+		image,
+		ancestor,
+		&dex.GraphStoreRequest_Dir{
+			Path: dir,
+			Settings: guitarconf.Settings{
+				Epoch: opts.Epoch,
+			},
+		},
+	)
+
+	Println("Committed", image + "@" + hash)
+	return nil
+}
@@ -0,0 +1,33 @@
+package commands
+
+import (
+	"os"
+	"polydawn.net/hroot/conf"
+	. "polydawn.net/hroot/util"
+)
+
+type ConfigCmdOpts struct {
+	JSON        bool   `long:"json" description:"Print JSON instead of TOML."`
+	Profile     string `long:"profile" description:"Configuration profile to use. (default: $HROOT_PROFILE)"`
+}
+
+//Prints the configuration hroot would use here, and where each value came from
+func (opts *ConfigCmdOpts) Execute(args []string) error {
+	if len(args) > 1 {
+		ExitGently("Usage: hroot config [target] [--json] [--profile name]")
+	}
+	target := GetTarget(args, "")
+
+	//Load configuration, keeping track of which file said what
+	parser := &conf.FileConfigParser{}
+	parser.UseProfile(selectProfile(opts.Profile))
+	configuration, _ := conf.LoadConfigurationFromDisk(".", configFile, parser)
+	printWarnings(parser)
+
+	if opts.JSON {
+		conf.DescribeJSON(os.Stdout, configuration, parser.GetProvenance(), target)
+	} else {
+		conf.DescribeTOML(os.Stdout, configuration, parser.GetProvenance(), target)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package commands
+
+import (
+	. "fmt"
+	"polydawn.net/hroot/dex/tarfile"
+	. "polydawn.net/hroot/util"
+)
+
+type ExportCmdOpts struct { }
+
+//Exports an image from the graph to a tar file, without involving docker
+func (opts *ExportCmdOpts) Execute(args []string) error {
+	if len(args) != 2 {
+		ExitGently("Usage: hroot export <image>[@hash] <file>")
+	}
+	image, version := SplitImageHash(args[0])
+	path := SanePath(args[1])
+
+	//Open the graph
+	graph := OpenGraph()
+
+	//Stream the image straight from the graph to the file
+	Println("Exporting", image, "to", path)
+	hash := graph.LoadVersion(
+		image,
+		version,
+		&tarfile.LoadRequest{
+			Path: path,
+		},
+	)
+
+	Println("Exported", image + "@" + hash)
+	return nil
+}
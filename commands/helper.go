@@ -3,8 +3,11 @@ package commands
 //Helper struct holds all the state & shared functionality you need to run a hroot command.
 
 import (
+	"archive/tar"
 	. "fmt"
 	"os"
+	"path/filepath"
+	"strconv"
 	"time"
 	"strings"
 	"polydawn.net/hroot/conf"
@@ -14,6 +17,30 @@ import (
 	guitarconf "polydawn.net/guitar/conf"
 )
 
+//Options every command takes, for running somewhere other than the current directory
+type GlobalOpts struct {
+	Dir    func(string) `short:"C" value-name:"dir" description:"Run as if hroot was started in dir."`
+	Config func(string) `long:"config" value-name:"file" description:"Read configuration from this file instead of the hroot.toml files in the current directory and above."`
+}
+
+//Config file given with --config, if any
+var configFile string
+
+//Global options, applied as they're parsed so commands never see the difference
+var GlobalOptions = GlobalOpts{
+	Dir: func(dir string) {
+		if err := os.Chdir(dir); err != nil {
+			ExitGently("Cannot change to directory", dir + ":", err)
+		}
+	},
+	Config: func(file string) {
+		//Relative to where it was given, even if -C comes after
+		abs, err := filepath.Abs(file)
+		if err != nil { ExitGently("Cannot determine absolute path:", file) }
+		configFile = abs
+	},
+}
+
 //Holds everything needed to load/save docker images
 type ImagePath struct {
 	scheme string    //URI scheme
@@ -35,28 +62,38 @@ type Hroot struct {
 
 	//Configuration
 	folders  conf.Folders
+	graph    conf.Graph
 	image    conf.Image
 	settings conf.Container
+	signing  conf.Signing
 	launchImage     string //Stored separately so we don't modify config if needed later for export.
+	copyImage       string //The launch image with copies in it, if there are any; removed along with the container.
+	stages      [][]string //Files to take from other images in the graph.  Only builds set this.
+	trailers      []string //Commit message lines recording which versions those files came from.
+	stepImages    []string //Images left between steps that aren't cached; removed along with the container.
 }
 
 //Create a hroot struct
-func LoadHroot(args []string, defaultTarget, sourceURI, destURI string) *Hroot {
+func LoadHroot(args []string, defaultTarget, profile, sourceURI, destURI string) *Hroot {
 	//If there was no target specified, override it
 	target   := GetTarget(args, defaultTarget)
 
-	//Load toml parser
-	parser := &conf.TomlConfigParser{}
+	//Load config parser, with the profile if one was asked for
+	parser := &conf.FileConfigParser{}
+	parser.UseProfile(selectProfile(profile))
 
 	//Parse config file
-	configuration, folders := conf.LoadConfigurationFromDisk(".", parser)
+	configuration, folders := conf.LoadConfigurationFromDisk(".", configFile, parser)
+	printWarnings(parser)
 	config := configuration.Targets[target]
 
 	//Hroot struct
 	d := &Hroot {
 		folders:     *folders,
+		graph:       configuration.Graph,
 		image:       configuration.Image,
 		settings:    config,
+		signing:     configuration.Signing,
 		launchImage: configuration.Image.Name, //Stored separately (see above)
 	}
 
@@ -95,6 +132,9 @@ func LoadHroot(args []string, defaultTarget, sourceURI, destURI string) *Hroot {
 	if len(configuration.Settings.Command) > 0 {
 		ExitGently("Cannot specify a command in settings; instead, put them in a target!")
 	}
+	if len(configuration.Settings.Steps) > 0 {
+		ExitGently("Cannot specify steps in settings; instead, put them in a target!")
+	}
 
 	return d
 }
@@ -117,7 +157,7 @@ func (d *Hroot) PrepareInput() {
 	switch d.source.scheme {
 		case "graph":
 			//Look up the graph, and clear any unwanted state
-			d.source.graph = dex.NewGraph(d.folders.Graph)
+			d.source.graph = ConfigureGraph(d.sourceGraph(), d.signing)
 			Println("Opening source repository")
 		case "file":
 			//If the user did not specify an image path, set one
@@ -135,16 +175,10 @@ func (d *Hroot) PrepareOutput() {
 	switch d.dest.scheme {
 		case "graph":
 			//Look up the graph, and clear any unwanted state
-			d.dest.graph = dex.NewGraph(d.folders.Graph)
+			d.dest.graph = ConfigureGraph(d.destGraph(), d.signing)
 
 			//If the user's git config isn't ready, we want to tell them *before* building.
-			if !d.dest.graph.IsConfigReady() {
-				ExitGently("\n" +
-					"Git could not find a user name & email."                 + "\n"   +
-					"You'll need to set up git with the following commands:"  + "\n\n" +
-					"git config --global user.email \"you@example.com\""      + "\n"   +
-					"git config --global user.name \"Your Name\"")
-			}
+			RequireGitConfig(d.dest.graph)
 
 			//Cleanse the graph unless it'd be redundant.
 			Println("Opening destination repository")
@@ -164,6 +198,133 @@ func (d *Hroot) PrepareOutput() {
 	}
 }
 
+//Opens the graph to load from: the configured one, or the one the source URI names.
+//A graph elsewhere on the network is pulled from into the configured graph first.
+func (d *Hroot) sourceGraph() *dex.Graph {
+	location := graphLocation(d.graph, d.source.path)
+	if location == "" {
+		return dex.NewGraph(d.folders.Graph)
+	} else if !isURL(location) {
+		return dex.NewGraph(SanePath(location))
+	}
+
+	//Only the images this command loads: the one it launches, and any it takes files from
+	lineages := []string{ d.launchImage }
+	for _, stage := range d.stages {
+		lineage, _ := crocker.SplitImageName(stage[0])
+		found := false
+		for _, l := range lineages {
+			name, _ := crocker.SplitImageName(l)
+			found = found || name == lineage
+		}
+		if !found {
+			lineages = append(lineages, lineage)
+		}
+	}
+
+	graph := ConfigureGraph(dex.NewGraph(d.folders.Graph), d.signing)
+	Println("Pulling", strings.Join(lineages, ", "), "from", location)
+	graph.Pull(location, lineages...)
+	return graph
+}
+
+//Opens the graph to publish to: the configured one, or the one the destination URI names.
+func (d *Hroot) destGraph() *dex.Graph {
+	location := graphLocation(d.graph, d.dest.path)
+	if location == "" {
+		return dex.NewGraph(d.folders.Graph)
+	} else if isURL(location) {
+		ExitGently("Cannot publish to", location, "- only graphs on this machine can be published to.")
+	}
+	return dex.NewGraph(SanePath(location))
+}
+
+//Looks up a configured graph remote by name; anything else is already a location.
+func graphLocation(graph conf.Graph, name string) string {
+	if remote, ok := graph.Remotes[name]; ok {
+		return remote
+	}
+	return name
+}
+
+//Tells git URLs ("https://host/graph", "user@host:graph") from folders on this machine.
+func isURL(location string) bool {
+	if strings.Contains(location, "://") {
+		return true
+	}
+	colon, slash := strings.Index(location, ":"), strings.Index(location, "/")
+	return colon > 0 && (slash < 0 || colon < slash)
+}
+
+//The profile to use: the one given on the command line, or else the one in $HROOT_PROFILE, if either.
+func selectProfile(profile string) string {
+	if profile == "" {
+		return os.Getenv("HROOT_PROFILE")
+	}
+	return profile
+}
+
+//Loads configuration for commands that work on the graph.
+//Unlike LoadHroot, this does not require an image to be configured.
+func loadGraphConfiguration() (*conf.Configuration, *conf.Folders) {
+	parser := &conf.FileConfigParser{}
+	configuration, folders := conf.LoadConfigurationFromDisk(".", configFile, parser)
+	printWarnings(parser)
+	return configuration, folders
+}
+
+//Mentions problems in the config files that didn't stop them loading.
+//They go to stderr, so they stay out of anything a command prints for other programs.
+func printWarnings(parser *conf.FileConfigParser) {
+	for _, problem := range parser.Warnings() {
+		Fprintln(os.Stderr, "Warning:", problem)
+	}
+}
+
+//Opens the graph configured for the current directory, or exits if there isn't one.
+func OpenGraph() *dex.Graph {
+	configuration, folders := loadGraphConfiguration()
+	graph := dex.LoadGraph(folders.Graph)
+	if graph == nil {
+		ExitGently("No graph found at", folders.Graph)
+	}
+	return ConfigureGraph(graph, configuration.Signing)
+}
+
+//Opens the graph configured for the current directory, making one if needed.
+func CreateGraph() *dex.Graph {
+	configuration, folders := loadGraphConfiguration()
+	return ConfigureGraph(dex.NewGraph(folders.Graph), configuration.Signing)
+}
+
+//Opens the git repo configured as the graph for the current directory, even if it's too damaged to look like a graph.
+func InspectGraph() *dex.Graph {
+	configuration, folders := loadGraphConfiguration()
+	graph := dex.InspectGraph(folders.Graph)
+	if graph == nil {
+		ExitGently("No graph found at", folders.Graph)
+	}
+	return ConfigureGraph(graph, configuration.Signing)
+}
+
+//Applies signing configuration to a graph.
+func ConfigureGraph(graph *dex.Graph, signing conf.Signing) *dex.Graph {
+	graph.SignWith(signing.Key)
+	graph.RequireSignatures(signing.Keyring)
+	return graph
+}
+
+//Exits if git isn't ready to make commits in the graph.
+func RequireGitConfig(graph *dex.Graph) {
+	if !graph.IsConfigReady() {
+		ExitGently("\n" +
+			"Git could not find a user name & email."                 + "\n"   +
+			"You'll need to set up git with the following commands:"  + "\n\n" +
+			"git config --global user.email \"you@example.com\""      + "\n"   +
+			"git config --global user.name \"Your Name\"")
+	}
+}
+
 //Connects to the docker daemon
 func (d *Hroot) StartDocker(socketURI string) {
 	d.dock = crocker.Dial(socketURI)
@@ -234,14 +395,120 @@ func (d *Hroot) PrepareCache() {
 
 //Lanuch the container and wait for it to complete
 func (d *Hroot) Launch() {
-	Println("Launching container.")
 	c := d.settings
+	image := d.launchImage
 
-	//Map the struct values to crocker function params
-	d.container = crocker.Launch(d.dock, d.launchImage, c.Command, c.Attach, c.Privileged, c.Folder, c.DNS, c.Mounts, c.Ports, c.Environment)
+	//Files to copy in go into an image of their own, so they're part of the container's filesystem
+	copies := append(append([][]string{}, d.image.Copies...), c.Copies...)
+	if len(copies) > 0 || len(d.stages) > 0 {
+		d.copyImage = "hroot/copy:" + strconv.FormatInt(time.Now().UnixNano(), 10)
+		Println("Copying", len(d.stages) + len(copies), "paths into", image)
+		d.dock.BuildWithFiles(image, d.copyImage, func(fs *tar.Writer) {
+			//Host files go last, so they can stand in for anything from the graph
+			d.loadStages(fs)
+			crocker.WriteCopies(fs, fileCopies(copies))
+		})
+		image = d.copyImage
+	}
+
+	//Targets with steps run each in turn
+	if len(c.Steps) > 0 {
+		d.runSteps(image)
+	} else {
+		//Map the struct values to crocker function params
+		Println("Launching container.")
+		d.container = crocker.Launch(d.dock, image, c.Command, c.Attach, c.Privileged, c.Folder, c.DNS, c.Mounts, c.Ports, c.Environment)
+
+		//Wait for container
+		d.container.Wait()
+	}
 
-	//Wait for container
-	d.container.Wait()
+	//Bring back what it made, while the container's still around to copy from
+	d.extractArtifacts()
+}
+
+//Copies each artifact out of the finished container.
+//If any are missing, the rest are still copied, then hroot cleans up and fails rather than export an incomplete build.
+func (d *Hroot) extractArtifacts() {
+	missing := []string{}
+	for _, artifact := range d.settings.Artifacts {
+		Println("Copying", artifact[0], "to", artifact[1])
+		if !d.container.CopyOut(artifact[0], artifact[1]) {
+			missing = append(missing, artifact[0])
+		}
+	}
+
+	if len(missing) > 0 {
+		d.Cleanup()
+		ExitGently("Artifacts not found in the container:", strings.Join(missing, ", "))
+	}
+}
+
+//Writes the files taken from other images in the graph to a tar, and notes which versions they came from.
+//Nothing is run from those images; the files come straight from the graph.
+func (d *Hroot) loadStages(fs *tar.Writer) {
+	if len(d.stages) == 0 {
+		return
+	}
+
+	graph := d.source.graph
+	if graph == nil {
+		graph = dex.LoadGraph(d.folders.Graph)
+		if graph == nil {
+			ExitGently("No graph found at", d.folders.Graph, "to take files from.")
+		}
+		ConfigureGraph(graph, d.signing)
+	}
+
+	for _, stage := range d.stages {
+		version := ""
+		if len(stage) > 3 {
+			version = stage[3]
+		}
+
+		Println("Copying", stage[1], "from", stage[0], "to", stage[2])
+		gr := &dex.GraphLoadRequest_Extract{
+			Tarstream: fs,
+			Path:      stage[1],
+			To:        stage[2],
+		}
+		hash := graph.LoadVersion(stage[0], version, gr)
+		if !gr.Found {
+			ExitGently("Image", stage[0], "has nothing at", stage[1])
+		}
+
+		lineage, _ := crocker.SplitImageName(stage[0])
+		trailer := "Copied-From: " + lineage + " " + hash
+		found := false
+		for _, t := range d.trailers {
+			found = found || t == trailer
+		}
+		if !found {
+			d.trailers = append(d.trailers, trailer)
+		}
+	}
+}
+
+//Turns copy settings into what crocker needs to make them.
+func fileCopies(copies [][]string) []crocker.FileCopy {
+	result := []crocker.FileCopy{}
+	for _, entry := range copies {
+		copy := crocker.FileCopy{
+			From: entry[0],
+			To:   entry[1],
+		}
+		var err error
+		if len(entry) > 2 && entry[2] != "" {
+			copy.Uid, copy.Gid, err = conf.ParseOwner(entry[2])
+			if err != nil { ExitGently(err) }
+		}
+		if len(entry) > 3 && entry[3] != "" {
+			copy.Mode, err = conf.ParseMode(entry[3])
+			if err != nil { ExitGently(err) }
+		}
+		result = append(result, copy)
+	}
+	return result
 }
 
 //Prepare the hroot export
@@ -265,6 +532,7 @@ func (d *Hroot) ExportBuild(forceEpoch bool) error {
 						Epoch: forceEpoch,
 					},
 				},
+				d.trailers...,
 			)
 		case "file":
 			//Export a tar
@@ -291,6 +559,14 @@ func (d *Hroot) Cleanup() {
 	//Remove the container from cache if desired
 	if d.settings.Purge {
 		d.container.Purge()
+
+		//Images made along the way go too, now nothing's using them
+		for i := len(d.stepImages) - 1; i >= 0; i-- {
+			d.dock.RemoveImage(d.stepImages[i])
+		}
+		if d.copyImage != "" {
+			d.dock.RemoveImage(d.copyImage)
+		}
 	}
 
 	//Close the docker connection
@@ -0,0 +1,51 @@
+package commands
+
+import (
+	. "fmt"
+	"os"
+	"polydawn.net/hroot/dex/tarfile"
+	. "polydawn.net/hroot/util"
+	guitarconf "polydawn.net/guitar/conf"
+)
+
+type ImportCmdOpts struct {
+	Epoch       bool   `long:"epoch" description:"Force all file modtimes to epoch."`
+}
+
+//Publishes a tar file into the graph, without involving docker
+func (opts *ImportCmdOpts) Execute(args []string) error {
+	if len(args) != 2 {
+		ExitGently("Usage: hroot import <file> <image>")
+	}
+	path := SanePath(args[0])
+	image := args[1]
+
+	_, err := os.Stat(path)
+	if err != nil { ExitGently(err) }
+
+	//Open the graph, making one if needed
+	graph := CreateGraph()
+	RequireGitConfig(graph)
+
+	//If the image already exists, this is the next version of it
+	ancestor := ""
+	if graph.HasLineage(image) {
+		ancestor = image
+	}
+
+	//Stream the file straight into the graph
+	Println("Importing", path, "to", image)
+	hash := graph.Publish(
+		image,
+		ancestor,
+		&tarfile.StoreRequest{
+			Path: path,
+			Settings: guitarconf.Settings{
+				Epoch: opts.Epoch,
+			},
+		},
+	)
+
+	Println("Imported", image + "@" + hash)
+	return nil
+}
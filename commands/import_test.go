@@ -0,0 +1,60 @@
+package commands
+
+import (
+	"archive/tar"
+	"os"
+	"testing"
+	"github.com/coocood/assrt"
+	"polydawn.net/hroot/conf"
+	"polydawn.net/hroot/dex"
+	"polydawn.net/hroot/testutil"
+)
+
+//Runs a function in a fresh folder, with no personal or system configuration to pick up.
+func do(fn func()) {
+	testutil.Do(func() {
+		defer func(system, xdg string) {
+			conf.SystemConfigDir = system
+			os.Setenv("XDG_CONFIG_HOME", xdg)
+		}(conf.SystemConfigDir, os.Getenv("XDG_CONFIG_HOME"))
+		dir, _ := os.Getwd()
+		conf.SystemConfigDir = dir
+		os.Setenv("XDG_CONFIG_HOME", dir)
+
+		fn()
+	})
+}
+
+func writeTar(path string, contents string) {
+	out, err := os.Create(path)
+	if err != nil { panic(err); }
+	defer out.Close()
+
+	fs := tar.NewWriter(out)
+	fs.WriteHeader(&tar.Header{
+		Name:     "a",
+		Mode:     0644,
+		Size:     int64(len(contents)),
+		Typeflag: tar.TypeReg,
+	})
+	fs.Write([]byte(contents))
+	fs.Close()
+}
+
+func TestImportTwice(t *testing.T) {
+	do(func() {
+		assert := assrt.NewAssert(t)
+
+		writeTar("first.tar", "one")
+		writeTar("second.tar", "two")
+		assert.Nil((&ImportCmdOpts{}).Execute([]string{ "first.tar", "line" }))
+
+		// the second import is the next version of the image, not a new one
+		assert.Nil((&ImportCmdOpts{}).Execute([]string{ "second.tar", "line" }))
+
+		graph := dex.LoadGraph(conf.GraphFolder)
+		assert.Equal([]string{ "line" }, graph.Lineages())
+		report := graph.Verify()
+		assert.Equal(0, report.Problems, report.Messages)
+	})
+}
@@ -0,0 +1,73 @@
+package commands
+
+import (
+	. "fmt"
+	"strings"
+	. "polydawn.net/hroot/util"
+)
+
+//Groups the lineage subcommands; does nothing on its own
+type LineageCmdOpts struct { }
+
+type LineageRenameCmdOpts struct { }
+
+//Renames a lineage in the graph
+func (opts *LineageRenameCmdOpts) Execute(args []string) error {
+	if len(args) != 2 {
+		ExitGently("Usage: hroot lineage rename <image> <new name>")
+	}
+
+	graph := OpenGraph()
+	RequireGitConfig(graph)
+	if !graph.HasLineage(args[0]) {
+		ExitGently("Image branch name", args[0], "not found in graph.")
+	}
+
+	//Images built from the old name need their configuration updated
+	downstreams := graph.Downstreams(args[0])
+	graph.Rename(args[0], args[1])
+	if len(downstreams) > 0 {
+		Println("Images built from", args[0] + ":", strings.Join(downstreams, ", "))
+		Println("Update their upstream to", args[1], "before building them again.")
+	}
+	return nil
+}
+
+type LineageForkCmdOpts struct { }
+
+//Starts a new lineage from a version of another image
+func (opts *LineageForkCmdOpts) Execute(args []string) error {
+	if len(args) != 2 {
+		ExitGently("Usage: hroot lineage fork <image> <new name>")
+	}
+
+	graph := OpenGraph()
+	RequireGitConfig(graph)
+
+	hash := graph.Fork(args[0], args[1])
+	Println("Forked", args[1], "from", args[0], "as", hash)
+	return nil
+}
+
+type LineageDeleteCmdOpts struct {
+	Force       bool   `short:"f" long:"force" description:"Delete the lineage even if other images are built from it."`
+}
+
+//Deletes a lineage from the graph
+func (opts *LineageDeleteCmdOpts) Execute(args []string) error {
+	if len(args) != 1 {
+		ExitGently("Usage: hroot lineage delete <image> [--force]")
+	}
+
+	graph := OpenGraph()
+	if !graph.HasLineage(args[0]) {
+		ExitGently("Image branch name", args[0], "not found in graph.")
+	}
+
+	//Versions other images were built from stay in the graph
+	downstreams := graph.DeleteLineage(args[0], opts.Force)
+	if len(downstreams) > 0 {
+		Println("History shared with", strings.Join(downstreams, ", "), "is kept.")
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package commands
+
+import (
+	"strings"
+	"polydawn.net/hroot/conf"
+	. "polydawn.net/hroot/util"
+)
+
+//Settings that can be changed for one run, on top of the configuration
+type OverrideOpts struct {
+	Volumes     []string `short:"v" long:"volume"  value-name:"host:container[:ro|rw]" description:"Add a mount."`
+	Environment []string `short:"e" long:"env"     value-name:"NAME=value"             description:"Set an environment variable."`
+	Ports       []string `short:"p" long:"publish" value-name:"host:container"         description:"Forward a port."`
+	Privileged  bool     `long:"privileged"                                            description:"Run in privileged mode."`
+	Set         []string `long:"set"               value-name:"key=value"              description:"Change any container setting, like folder=/src or dns=8.8.8.8."`
+}
+
+//Layers the overrides over a container's settings, and appends any extra arguments to its command (or its last step's).
+func (opts *OverrideOpts) Apply(c *conf.Container, extra []string) {
+	set := func(key, value string) {
+		if err := c.Set(key, value); err != nil {
+			ExitGently("Cannot override settings:", err)
+		}
+	}
+
+	for _, assignment := range opts.Set {
+		parts := strings.SplitN(assignment, "=", 2)
+		if len(parts) != 2 {
+			ExitGently("Cannot override settings: --set takes key=value, not", assignment)
+		}
+		set(parts[0], parts[1])
+	}
+	for _, volume := range opts.Volumes {
+		set("mounts", volume)
+	}
+	for _, variable := range opts.Environment {
+		set("environment", variable)
+	}
+	for _, port := range opts.Ports {
+		set("ports", port)
+	}
+	if opts.Privileged {
+		c.Privileged = true
+	}
+
+	//Targets that run in steps take the arguments on their last step
+	if len(c.Steps) > 0 {
+		last := &c.Steps[len(c.Steps)-1]
+		last.Command = append(append([]string{}, last.Command...), extra...)
+	} else {
+		c.Command = append(c.Command, extra...)
+	}
+}
+
+/*
+	Splits a command's arguments into its own, and the ones after "--" that are meant for the container.
+	The "--" is the one MarkPassthrough left in the arguments, so it's exactly where the flag parser stopped.
+*/
+func splitPassthrough(args []string) ([]string, []string) {
+	for i, arg := range args {
+		if arg == "--" {
+			return args[:i], args[i+1:]
+		}
+	}
+	return args, nil
+}
+
+/*
+	Prepares a command line for the flag parser, so the given commands can tell which arguments came after "--".
+	The parser swallows the "--" that ends options, so for those commands it's doubled, leaving one for splitPassthrough.
+	Only the global options that take values (-C and --config) can come before the command's name.
+*/
+func MarkPassthrough(args []string, commands ...string) []string {
+	name := ""
+	for i := 0; i < len(args) && name == ""; i++ {
+		switch {
+			case args[i] == "--":
+				return args
+			case args[i] == "-C" || args[i] == "--config":
+				i++
+			case !strings.HasPrefix(args[i], "-"):
+				name = args[i]
+		}
+	}
+
+	found := false
+	for _, command := range commands {
+		found = found || command == name
+	}
+	if !found {
+		return args
+	}
+	for i, arg := range args {
+		if arg == "--" {
+			return append(append(append([]string{}, args[:i+1]...), "--"), args[i+1:]...)
+		}
+	}
+	return args
+}
@@ -0,0 +1,62 @@
+package commands
+
+import (
+	"testing"
+	"github.com/coocood/assrt"
+	"github.com/jessevdk/go-flags"
+	"polydawn.net/hroot/conf"
+)
+
+//A stand-in for run, that keeps what it was handed instead of launching anything
+type passthroughCmd struct {
+	OverrideOpts
+	own   []string
+	extra []string
+}
+
+func (opts *passthroughCmd) Execute(args []string) error {
+	opts.own, opts.extra = splitPassthrough(args)
+	return nil
+}
+
+func parsePassthrough(args ...string) *passthroughCmd {
+	global := GlobalOpts{
+		Dir:    func(string) {},
+		Config: func(string) {},
+	}
+	cmd := &passthroughCmd{}
+	parser := flags.NewNamedParser("hroot", flags.Default)
+	parser.AddGroup("Global options", "", &global)
+	parser.AddCommand("run", "", "", cmd)
+	parser.AddCommand("show", "", "", &passthroughCmd{})
+	if _, err := parser.ParseArgs(MarkPassthrough(args, "run")); err != nil {
+		panic(err)
+	}
+	return cmd
+}
+
+func TestPassthrough(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	cmd := parsePassthrough("run", "-e", "A=1", "target", "--", "ls", "-l", "--", "-e")
+	assert.Equal([]string{"target"}, cmd.own)
+	assert.Equal([]string{"ls", "-l", "--", "-e"}, cmd.extra)
+	assert.Equal([]string{"A=1"}, cmd.Environment)
+
+	cmd = parsePassthrough("-C", "run", "run", "--", "target")
+	assert.Equal(0, len(cmd.own))
+	assert.Equal([]string{"target"}, cmd.extra)
+
+	cmd = parsePassthrough("run", "target", "-e", "A=1")
+	assert.Equal([]string{"target"}, cmd.own)
+	assert.Equal(0, len(cmd.extra))
+
+	//Other commands get their arguments as the flag parser left them
+	assert.Equal([]string{"show", "--", "-e"}, MarkPassthrough([]string{"show", "--", "-e"}, "run"))
+	assert.Equal([]string{"--config", "x", "--", "run"}, MarkPassthrough([]string{"--config", "x", "--", "run"}, "run"))
+
+	c := conf.DefaultContainer.Copy()
+	c.Command = []string{"bash"}
+	(&OverrideOpts{}).Apply(&c, []string{"-c", "true"})
+	assert.Equal([]string{"bash", "-c", "true"}, c.Command)
+}
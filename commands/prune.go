@@ -0,0 +1,51 @@
+package commands
+
+import (
+	. "fmt"
+	"polydawn.net/hroot/crocker"
+	. "polydawn.net/hroot/util"
+)
+
+type PruneCmdOpts struct {
+	Keep        int    `long:"keep"   description:"Keep full history for this many of the newest versions."`
+	Before      string `long:"before" description:"Squash history of versions older than this date."`
+}
+
+//Squashes old history of an image in the graph
+func (opts *PruneCmdOpts) Execute(args []string) error {
+	if len(args) > 1 {
+		ExitGently("Usage: hroot prune [image] [--keep N] [--before date]")
+	}
+	configuration, _ := loadGraphConfiguration()
+
+	//Default to the image configured in this folder
+	image := configuration.Image.Name
+	if len(args) == 1 {
+		image = args[0]
+	}
+	if image == "" {
+		ExitGently("No image name specified.")
+	}
+
+	//Retention settings for the configured image apply unless overridden
+	retention := configuration.Image.Prune
+	name, _ := crocker.SplitImageName(image)
+	configured, _ := crocker.SplitImageName(configuration.Image.Name)
+	if name != configured {
+		retention.Keep, retention.Before = 0, ""
+	}
+	if opts.Keep > 0 {
+		retention.Keep = opts.Keep
+	}
+	if opts.Before != "" {
+		retention.Before = opts.Before
+	}
+	if retention.Keep <= 0 && retention.Before == "" {
+		ExitGently("Say how much history to keep with --keep or --before, or configure [image.prune] in hroot.toml.")
+	}
+
+	graph := OpenGraph()
+	squashed := graph.Prune(name, retention.Keep, retention.Before)
+	Println("Squashed", squashed, "versions of", name)
+	return nil
+}
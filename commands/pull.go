@@ -0,0 +1,31 @@
+package commands
+
+import (
+	. "fmt"
+	"os"
+	"polydawn.net/hroot/dex"
+	. "polydawn.net/hroot/util"
+)
+
+type PullCmdOpts struct { }
+
+//Pulls images from another graph
+func (opts *PullCmdOpts) Execute(args []string) error {
+	if len(args) < 1 {
+		ExitGently("Usage: hroot pull <url|remote> [image...]")
+	}
+
+	//Open the graph, making one if needed
+	configuration, folders := loadGraphConfiguration()
+	graph := ConfigureGraph(dex.NewGraph(folders.Graph), configuration.Signing)
+
+	//The graph runs git from its own folder, so pin down local paths
+	url := graphLocation(configuration.Graph, args[0])
+	if _, err := os.Stat(url); err == nil {
+		url = SanePath(url)
+	}
+
+	Println("Pulling from", url)
+	graph.Pull(url, args[1:]...)
+	return nil
+}
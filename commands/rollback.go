@@ -0,0 +1,28 @@
+package commands
+
+import (
+	. "fmt"
+	. "polydawn.net/hroot/util"
+)
+
+type RollbackCmdOpts struct {
+	Reason      string `short:"m" long:"reason" description:"Why the image is being rolled back; saved in the graph."`
+}
+
+//Publishes an earlier version of an image as its newest version
+func (opts *RollbackCmdOpts) Execute(args []string) error {
+	if len(args) != 2 {
+		ExitGently("Usage: hroot rollback <image> <hash> -m <reason>")
+	}
+	if opts.Reason == "" {
+		ExitGently("Say why the image is being rolled back with -m.")
+	}
+
+	//Open the graph
+	graph := OpenGraph()
+	RequireGitConfig(graph)
+
+	hash := graph.Rollback(args[0], args[1], opts.Reason)
+	Println("Rolled back", args[0], "as", hash)
+	return nil
+}
@@ -2,19 +2,29 @@ package commands
 
 import (
 	. "fmt"
+	. "polydawn.net/hroot/util"
 )
 
 type RunCmdOpts struct {
 	DockerH     string `short:"H"               description:"Where to connect to docker daemon."`
 	Source      string `short:"s" long:"source" description:"Container source."`
+	Profile     string `long:"profile"          description:"Configuration profile to use. (default: $HROOT_PROFILE)"`
+	OverrideOpts
 }
 
 const DefaultRunTarget = "run"
 
 //Runs a container
 func (opts *RunCmdOpts) Execute(args []string) error {
-	//Load settings
-	hroot := LoadHroot(args, DefaultRunTarget, opts.Source, "")
+	//Anything after -- goes on the end of the command
+	args, extra := splitPassthrough(args)
+	if len(args) > 1 {
+		ExitGently("Usage: hroot run [target] [-- arguments...]")
+	}
+
+	//Load settings, and apply any overrides
+	hroot := LoadHroot(args, DefaultRunTarget, opts.Profile, opts.Source, "")
+	opts.Apply(&hroot.settings, extra)
 	Println("Running", hroot.image.Name)
 	hroot.PrepareInput()
 
@@ -0,0 +1,28 @@
+package commands
+
+import (
+	. "fmt"
+	"net/http"
+	"polydawn.net/hroot/dex"
+	. "polydawn.net/hroot/util"
+)
+
+type ServeCmdOpts struct {
+	Listen      string `short:"l" long:"listen" default:":8080" description:"Address to listen on."`
+}
+
+//Serves the graph read-only over HTTP
+func (opts *ServeCmdOpts) Execute(args []string) error {
+	if len(args) != 0 {
+		ExitGently("Usage: hroot serve [--listen address]")
+	}
+
+	//Open the graph
+	graph := OpenGraph()
+
+	Println("Serving graph on", opts.Listen)
+	Println("Pull from http://<host>"+opts.Listen+"/git, or browse http://<host>"+opts.Listen+"/api/lineages")
+	err := http.ListenAndServe(opts.Listen, dex.NewGraphServer(graph))
+	if err != nil { ExitGently(err) }
+	return nil
+}
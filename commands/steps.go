@@ -0,0 +1,99 @@
+package commands
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	. "fmt"
+	"strconv"
+	"strings"
+	"time"
+	"polydawn.net/hroot/conf"
+	"polydawn.net/hroot/crocker"
+	. "polydawn.net/hroot/util"
+)
+
+//Docker image name that step results are kept under, tagged by step key
+const stepImageName = "hroot/step"
+
+/*
+	Runs a target's steps in order, each in a container made from what the last one left.
+	A build starts after the last step whose result is cached, so long as nothing about that step,
+	the steps before it, or the image under them has changed; the rest run, and cache their results if asked to.
+	The last container is the one that gets exported.
+*/
+func (d *Hroot) runSteps(image string) {
+	steps := d.settings.Steps
+
+	//Each step's key covers everything that went into its result
+	keys := []string{}
+	key := d.dock.ImageID(image)
+	for _, step := range steps {
+		key = stepKey(key, d.settings.ForStep(step))
+		keys = append(keys, key)
+	}
+
+	start := 0
+	for i := len(steps) - 1; i >= 0; i-- {
+		if steps[i].Cache && d.dock.CheckCache(stepImageName + ":" + keys[i]) {
+			Println("Step", i + 1, "of", len(steps), "is cached, starting after it.")
+			image = stepImageName + ":" + keys[i]
+			start = i + 1
+			break
+		}
+	}
+
+	//Nothing left to run, but there has to be a container to export
+	if start == len(steps) {
+		d.container = d.launchStep(image, d.settings.ForStep(conf.Step{ Command: []string{ "/bin/true" } }))
+		return
+	}
+
+	for i := start; i < len(steps); i++ {
+		c := d.settings.ForStep(steps[i])
+		Println("Running step", i + 1, "of", strconv.Itoa(len(steps)) + ":", strings.Join(c.Command, " "))
+		d.container = d.launchStep(image, c)
+
+		//Don't build on, or cache, a step that failed
+		if code := d.container.ExitCode(); code != 0 {
+			d.Cleanup()
+			ExitGently("Step", i + 1, "failed with exit code", code)
+		}
+
+		//Keep the result, either in the cache or long enough for the next step to start from it
+		if steps[i].Cache {
+			image = stepImageName + ":" + keys[i]
+		} else if i < len(steps) - 1 {
+			image = stepImageName + ":tmp-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+			d.stepImages = append(d.stepImages, image)
+		} else {
+			break
+		}
+		name, tag := crocker.SplitImageName(image)
+		d.container.Commit(name, tag)
+
+		if i < len(steps) - 1 && d.settings.Purge {
+			d.container.Purge()
+		}
+	}
+}
+
+//Launches a container for one step, and waits for it to finish.
+func (d *Hroot) launchStep(image string, c conf.Container) *crocker.Container {
+	container := crocker.Launch(d.dock, image, c.Command, c.Attach, c.Privileged, c.Folder, c.DNS, c.Mounts, c.Ports, c.Environment)
+	container.Wait()
+	return container
+}
+
+/*
+	Returns the cache key for a step: a hash of the key before it (or the image it starts from) and the settings it runs with.
+	Only the settings are hashed, so a step that reads files from a mount won't notice them changing; use copy for those.
+*/
+func stepKey(previous string, c conf.Container) string {
+	data, err := json.Marshal([]interface{}{ previous, c.Command, c.Folder, c.Environment, c.Mounts, c.Privileged })
+	if err != nil { panic(err); }
+	sum := sha1.Sum(data)
+
+	//Docker keeps tags short
+	return hex.EncodeToString(sum[:])[:24]
+}
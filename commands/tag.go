@@ -0,0 +1,25 @@
+package commands
+
+import (
+	. "polydawn.net/hroot/util"
+)
+
+type TagCmdOpts struct { }
+
+//Points an image tag at a version in the graph
+func (opts *TagCmdOpts) Execute(args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		ExitGently("Usage: hroot tag <image> <tag> [hash]")
+	}
+	image, tag := args[0], args[1]
+	version := ""
+	if len(args) == 3 {
+		version = args[2]
+	}
+
+	//Open the graph
+	graph := OpenGraph()
+
+	graph.Tag(image, tag, version)
+	return nil
+}
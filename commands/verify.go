@@ -0,0 +1,37 @@
+package commands
+
+import (
+	. "fmt"
+	"os"
+)
+
+type VerifyCmdOpts struct {
+	Quiet       bool   `short:"q" long:"quiet" description:"Only report problems through the exit code."`
+}
+
+//Exit codes for a graph that fails verification: ExitVerifyFailed, plus the dex.Verify* bits for the kinds of problems found.
+const ExitVerifyFailed = 16
+
+//Checks the integrity of lineages in the graph
+func (opts *VerifyCmdOpts) Execute(args []string) error {
+	graph := InspectGraph()
+
+	report := graph.Verify(args...)
+	if !opts.Quiet {
+		for _, message := range report.Messages {
+			Println(message)
+		}
+	}
+
+	if report.Problems != 0 {
+		if !opts.Quiet {
+			Println("Graph failed verification.")
+		}
+		os.Exit(ExitVerifyFailed | report.Problems)
+	}
+
+	if !opts.Quiet {
+		Println("Graph verified.")
+	}
+	return nil
+}
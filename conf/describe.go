@@ -0,0 +1,234 @@
+package conf
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	. "polydawn.net/hroot/util"
+)
+
+/*
+	Writes a configuration as TOML, with a comment after each value naming the file that set it.
+	Lists that were appended to across files get one line per element, each with its own file.
+	Values nothing set are marked as defaults.
+
+	If a target is named, only that target is written, in place of the settings and other targets.
+*/
+func DescribeTOML(w io.Writer, c *Configuration, p Provenance, target string) {
+	for _, section := range describe(c, p, target) {
+		section.writeTOML(w)
+	}
+}
+
+/*
+	Writes a configuration as JSON.
+	Each value is an object holding the value and the list of files that set it (empty for defaults),
+	with one file per element for lists that were appended to.
+
+	If a target is named, only that target is written, in place of the settings and other targets.
+*/
+func DescribeJSON(w io.Writer, c *Configuration, p Provenance, target string) {
+	out := map[string]interface{}{}
+	for _, section := range describe(c, p, target) {
+		table := out
+		for _, name := range section.path {
+			if _, ok := table[name]; !ok {
+				table[name] = map[string]interface{}{}
+			}
+			table = table[name].(map[string]interface{})
+		}
+		for _, entry := range section.entries {
+			table[entry.name] = map[string]interface{}{
+				"value": entry.value.Interface(),
+				"from":  entry.sources,
+			}
+		}
+	}
+
+	data, err := json.MarshalIndent(out, "", "\t")
+	if err != nil { panic(err); }
+	fmt.Fprintln(w, string(data))
+}
+
+//One table of described configuration
+type describedSection struct {
+	path    []string
+	entries []describedEntry
+}
+
+//One described value
+type describedEntry struct {
+	name    string
+	value   reflect.Value
+	sources []string
+}
+
+//Flattens a configuration into tables, in the order TOML would have them.
+func describe(c *Configuration, p Provenance, target string) []describedSection {
+	if target != "" {
+		container, ok := c.Targets[target]
+		if !ok {
+			ExitGently("No target named", target, "is configured.")
+		}
+		sections := describeStruct(reflect.ValueOf(c.Image), []string{ "image" }, p)
+		sections = append(sections, describeStruct(reflect.ValueOf(c.Signing), []string{ "signing" }, p)...)
+		return append(sections, describeStruct(reflect.ValueOf(container), []string{ "target", target }, p)...)
+	}
+	return describeStruct(reflect.ValueOf(*c), nil, p)
+}
+
+func describeStruct(v reflect.Value, path []string, p Provenance) []describedSection {
+	section := describedSection{ path: path }
+	subsections := []describedSection{}
+	for i := 0; i < v.NumField(); i++ {
+		name := strings.Split(v.Type().Field(i).Tag.Get("toml"), ",")[0]
+		if name == "" || name == "-" || v.Type().Field(i).Tag.Get("describe") == "-" {
+			continue
+		}
+		field := v.Field(i)
+		fieldPath := append(append([]string{}, path...), name)
+
+		switch {
+			case field.Kind() == reflect.Struct:
+				subsections = append(subsections, describeStruct(field, fieldPath, p)...)
+			case field.Kind() == reflect.Map && field.Type().Elem().Kind() == reflect.Struct:
+				keys := []string{}
+				for _, key := range field.MapKeys() {
+					keys = append(keys, key.String())
+				}
+				sort.Strings(keys)
+				for _, key := range keys {
+					subsections = append(subsections, describeStruct(field.MapIndex(reflect.ValueOf(key)), append(fieldPath, key), p)...)
+				}
+			case field.Kind() == reflect.Map:
+				keys := []string{}
+				for _, key := range field.MapKeys() {
+					keys = append(keys, key.String())
+				}
+				sort.Strings(keys)
+				table := describedSection{ path: fieldPath }
+				for _, key := range keys {
+					sources := p[strings.Join(append(fieldPath, key), ".")]
+					if sources == nil {
+						sources = []string{}
+					}
+					table.entries = append(table.entries, describedEntry{
+						name:    key,
+						value:   field.MapIndex(reflect.ValueOf(key)),
+						sources: sources,
+					})
+				}
+				subsections = append(subsections, table)
+			default:
+				sources := p[strings.Join(fieldPath, ".")]
+				if sources == nil {
+					sources = []string{}
+				}
+				section.entries = append(section.entries, describedEntry{
+					name:    name,
+					value:   field,
+					sources: sources,
+				})
+		}
+	}
+	if path == nil {
+		return subsections
+	}
+	return append([]describedSection{ section }, subsections...)
+}
+
+func (s describedSection) writeTOML(w io.Writer) {
+	if len(s.entries) == 0 {
+		return
+	}
+	names := []string{}
+	for _, name := range s.path {
+		names = append(names, tomlKey(name))
+	}
+	fmt.Fprintf(w, "[%s]\n", strings.Join(names, "."))
+
+	arrays := []describedEntry{}
+	for _, entry := range s.entries {
+		// lists of tables have to come after the table's own values.
+		if entry.value.Kind() == reflect.Slice && entry.value.Len() > 0 && entry.value.Type().Elem().Kind() == reflect.Struct {
+			arrays = append(arrays, entry)
+			continue
+		}
+
+		// appended lists get an element per line, so each can say where it came from.
+		if entry.value.Kind() == reflect.Slice && entry.value.Len() > 1 && len(entry.sources) == entry.value.Len() {
+			fmt.Fprintf(w, "\t%s = [\n", tomlKey(entry.name))
+			for i := 0; i < entry.value.Len(); i++ {
+				fmt.Fprintf(w, "\t\t%s,  # %s\n", tomlValue(entry.value.Index(i)), entry.sources[i])
+			}
+			fmt.Fprintf(w, "\t]\n")
+			continue
+		}
+
+		from := "default"
+		if len(entry.sources) > 0 {
+			from = strings.Join(uniq(entry.sources), ", ")
+		}
+		fmt.Fprintf(w, "\t%s = %s  # %s\n", tomlKey(entry.name), tomlValue(entry.value), from)
+	}
+	fmt.Fprintln(w)
+
+	for _, entry := range arrays {
+		from := strings.Join(uniq(entry.sources), ", ")
+		for i := 0; i < entry.value.Len(); i++ {
+			fmt.Fprintf(w, "[[%s]]\n", strings.Join(append(names, tomlKey(entry.name)), "."))
+			table := entry.value.Index(i)
+			for j := 0; j < table.NumField(); j++ {
+				name := strings.Split(table.Type().Field(j).Tag.Get("toml"), ",")[0]
+				fmt.Fprintf(w, "\t%s = %s  # %s\n", tomlKey(name), tomlValue(table.Field(j)), from)
+			}
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+func tomlKey(key string) string {
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return strconv.Quote(key)
+		}
+	}
+	return key
+}
+
+func tomlValue(v reflect.Value) string {
+	switch v.Kind() {
+		case reflect.String:
+			return strconv.Quote(v.String())
+		case reflect.Bool:
+			return strconv.FormatBool(v.Bool())
+		case reflect.Int, reflect.Int64:
+			return strconv.FormatInt(v.Int(), 10)
+		case reflect.Slice:
+			elems := []string{}
+			for i := 0; i < v.Len(); i++ {
+				elems = append(elems, tomlValue(v.Index(i)))
+			}
+			if len(elems) == 0 {
+				return "[]"
+			}
+			return "[ " + strings.Join(elems, ", ") + " ]"
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+func uniq(list []string) []string {
+	seen := map[string]bool{}
+	out := []string{}
+	for _, s := range list {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
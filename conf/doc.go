@@ -1,13 +1,28 @@
 /*
 	This package contains the config file loading system used by hroot.
-	It produces conf.Settings from TOML-formatted configuration files.
+	It produces conf.Settings from configuration files: hroot.toml, or hroot.json for those who'd rather.
+	Other formats can be added with RegisterFormat; each folder may have one config file, in any format.
 
 	Config files can arranged in nested directories, will loaded recursively, with config values accumulating
 	and the deeper config files overriding the values from the shallower files, providing a simple
-	structure for inheriting common configuration.
+	structure for inheriting common configuration.  The search stops early at a file with "root = true".
+	The user's ~/.config/hroot/ and the system's /etc/ config files are layered underneath.
 
-	This package isolates conf.Settings from any specific knowledge of TOML (admittedly,
-	conf.Settings is annotated to help the toml loader; but it does *not* have a
-	compile-time dep on a toml library).
+	Single values from deeper files replace shallower ones.  Lists (mounts, ports, dns, environment, copy, artifacts) are added to,
+	except that environment variables override by name, a file can list keys under "replace" to use its own lists
+	instead of the inherited ones, and a "remove" table drops inherited entries by key (mounts by container folder,
+	ports by host port, dns by server, environment by name, copy and artifacts by container path) before the file's own entries are added.
+
+	A target's steps are a sequence, so they're replaced whole rather than added to, and a command or steps from a
+	deeper file takes over from the other.
+
+	A profile ("profile.<name>" sections) can change settings and targets, and when selected, goes on top of
+	every file's own settings and targets.
+
+	Values can use ${VAR} from the host environment and a few built-ins (see interpolate.go).  Most are expanded
+	as each file loads; ${TARGET} and ${IMAGE_NAME} wait until each target has been merged.
+
+	Only toml.go knows about TOML (admittedly, conf.Settings is annotated to help the toml
+	and json decoders; but it does *not* have a compile-time dep on a toml library).
 */
 package conf
@@ -12,43 +12,186 @@ const GraphFolder    = "graph"
 //Image and parent image
 type Image struct {
 	//What image to use
-	Name        string     `toml:"name"`
+	Name        string     `toml:"name" json:"name"`
 
 	//What image to build from
-	Upstream    string     `toml:"upstream"`
+	Upstream    string     `toml:"upstream" json:"upstream"`
 
 	//What the upstream image is called in the docker index
-	Index       string     `toml:"index"`
+	Index       string     `toml:"index" json:"index"`
+
+	//How much history to keep when pruning the image
+	Prune       Retention  `toml:"prune" json:"prune"`
+
+	//Host files to copy into every container made from the image (each an array of strings: hostpath, guestpath, and optionally owner and mode)
+	Copies      [][]string `toml:"copy" json:"copy"`
+
+	//Files to take from other images in the graph when building (each an array of strings: image, path in that image, guestpath, and optionally the version's hash)
+	From        [][]string `toml:"from" json:"from"`
+}
+
+//Localize an image object to a given folder
+func (img *Image) Localize(dir string) {
+	//Get the absolute directory this config is relative to
+	cwd, err := filepath.Abs(dir)
+	if err != nil { ExitGently("Cannot determine absolute path: ", dir) }
+
+	localizeCopies(img.Copies, cwd)
+}
+
+//How much of an image's history to keep when pruning
+type Retention struct {
+	//Keep full history for this many of the newest versions
+	Keep        int        `toml:"keep" json:"keep"`
+
+	//Keep full history for versions newer than this date (anything git understands, like "2014-06-01" or "3 months ago")
+	Before      string     `toml:"before" json:"before"`
+}
+
+//Commit signing and verification
+type Signing struct {
+	//GPG key to sign published images with (anything gpg accepts as a key ID)
+	Key         string     `toml:"key" json:"key"`
+
+	//File of trusted public keys. If set, images must be signed by one of these keys to be loaded or pulled.
+	Keyring     string     `toml:"keyring" json:"keyring"`
+}
+
+//Localize a signing object to a given folder
+func (s *Signing) Localize(dir string) {
+	if s.Keyring == "" {
+		return
+	}
+
+	//Get the absolute directory this config is relative to
+	cwd, err := filepath.Abs(dir)
+	if err != nil { ExitGently("Cannot determine absolute path: ", dir) }
+
+	//Check for triple-dot ... notation, which is relative to that config's directory, not the CWD
+	if strings.Index(s.Keyring, "...") == 0 {
+		s.Keyring = strings.Replace(s.Keyring, "...", cwd, 1)
+	}
+
+	abs, err := filepath.Abs(s.Keyring)
+	if err != nil { ExitGently("Cannot determine absolute path:", s.Keyring) }
+	s.Keyring = abs
+}
+
+//Where images are kept
+type Graph struct {
+	//Folder of the graph; defaults to a graph folder next to the highest hroot.toml
+	Path        string            `toml:"path" json:"path"`
+
+	//Other graphs, by name, as folders or git URLs
+	Remotes     map[string]string `toml:"remotes" json:"remotes"`
+}
+
+//Localize a graph object to a given folder
+func (g *Graph) Localize(dir string) {
+	//Get the absolute directory this config is relative to
+	cwd, err := filepath.Abs(dir)
+	if err != nil { ExitGently("Cannot determine absolute path: ", dir) }
+
+	//Check for triple-dot ... notation, which is relative to that config's directory, not the CWD
+	if strings.Index(g.Path, "...") == 0 {
+		g.Path = strings.Replace(g.Path, "...", cwd, 1)
+	}
+	if g.Path != "" {
+		abs, err := filepath.Abs(g.Path)
+		if err != nil { ExitGently("Cannot determine absolute path:", g.Path) }
+		g.Path = abs
+	}
+
+	//Remotes might be URLs, so only the ones that are clearly folders are expanded
+	for name, remote := range g.Remotes {
+		if strings.Index(remote, "...") == 0 {
+			g.Remotes[name] = strings.Replace(remote, "...", cwd, 1)
+		}
+	}
 }
 
 //A container's settings
 type Container struct {
+	//Which target this one builds on (targets only; settings always build on the parent folder's settings)
+	Extends     string     `toml:"extends" json:"extends"`
+
 	//What command to run
-	Command     []string   `toml:"command"`
+	Command     []string   `toml:"command" json:"command"`
 
 	//Which folder to start
-	Folder      string     `toml:"folder"`
+	Folder      string     `toml:"folder" json:"folder"`
 
 	//Run in privileged mode?
-	Privileged  bool       `toml:"privileged"`
+	Privileged  bool       `toml:"privileged" json:"privileged"`
 
 	//Array of mounts (each an array of strings: hostfolder, guestfolder, "ro"/"rw" permission)
-	Mounts      [][]string `toml:"mounts"`
+	Mounts      [][]string `toml:"mounts" json:"mounts"`
 
 	//What ports do you want to forward? (each an array of ints: hostport, guestport)
-	Ports       [][]string `toml:"ports"`
+	Ports       [][]string `toml:"ports" json:"ports"`
 
 	//Do you want to use custom DNS servers?
-	DNS         []string   `toml:"dns"`
+	DNS         []string   `toml:"dns" json:"dns"`
 
 	//Attach interactive terminal?
-	Attach      bool       `toml:"attach"`
+	Attach      bool       `toml:"attach" json:"attach"`
 
 	//Delete when done?
-	Purge       bool       `toml:"purge"`
+	Purge       bool       `toml:"purge" json:"purge"`
 
 	//Env variables (each an array of strings: variable, value)
-	Environment [][]string `toml:"environment"`
+	Environment [][]string `toml:"environment" json:"environment"`
+
+	//Host files to copy into the container before the command runs (each an array of strings: hostpath, guestpath, and optionally owner "uid[:gid]" and octal mode)
+	Copies      [][]string `toml:"copy" json:"copy"`
+
+	//Files and folders to copy out of the container after it exits (each an array of strings: guestpath, hostpath)
+	Artifacts   [][]string `toml:"artifacts" json:"artifacts"`
+
+	//Commands to run one after another instead of the command, each starting from what the last one left
+	Steps       []Step     `toml:"step" json:"step"`
+
+	//Lists to take from this file in place of the inherited ones, instead of adding to them ("mounts", "ports", "dns", "environment", "copy", "artifacts")
+	Replace     []string   `toml:"replace" json:"replace" describe:"-"`
+
+	//Inherited list entries to drop
+	Remove      Removals   `toml:"remove" json:"remove"  describe:"-"`
+}
+
+//One of a target's steps
+type Step struct {
+	//What command to run
+	Command     []string   `toml:"command" json:"command"`
+
+	//Which folder to start in; defaults to the target's
+	Folder      string     `toml:"folder" json:"folder"`
+
+	//Env variables on top of the target's, overriding by name (each an array of strings: variable, value)
+	Environment [][]string `toml:"environment" json:"environment"`
+
+	//Keep what this step leaves in docker's cache, so later builds can start after it while nothing up to it has changed
+	Cache       bool       `toml:"cache" json:"cache"`
+}
+
+//Inherited list entries for a config file to drop, each list by its own key
+type Removals struct {
+	//Mounts, by container folder
+	Mounts      []string   `toml:"mounts" json:"mounts"`
+
+	//Port forwards, by host port
+	Ports       []string   `toml:"ports" json:"ports"`
+
+	//DNS servers
+	DNS         []string   `toml:"dns" json:"dns"`
+
+	//Env variables, by name
+	Environment []string   `toml:"environment" json:"environment"`
+
+	//Copies, by container path
+	Copies      []string   `toml:"copy" json:"copy"`
+
+	//Artifacts, by container path
+	Artifacts   []string   `toml:"artifacts" json:"artifacts"`
 }
 
 //Localize a container object to a given folder
@@ -70,6 +213,81 @@ func (c *Container) Localize(dir string) {
 		if err != nil { ExitGently("Cannot determine absolute path:", c.Mounts[i][0]) }
 		c.Mounts[i][0] = abs
 	}
+
+	localizeCopies(c.Copies, cwd)
+
+	//Artifacts land on the host side, which comes second
+	for i := range c.Artifacts {
+		if strings.Index(c.Artifacts[i][1], "...") == 0 {
+			c.Artifacts[i][1] = strings.Replace(c.Artifacts[i][1], "...", cwd, 1)
+		}
+
+		abs, err := filepath.Abs(c.Artifacts[i][1])
+		if err != nil { ExitGently("Cannot determine absolute path:", c.Artifacts[i][1]) }
+		c.Artifacts[i][1] = abs
+	}
+}
+
+//Makes the host side of each copy absolute, with ... meaning the given config folder
+func localizeCopies(copies [][]string, cwd string) {
+	for i := range copies {
+		//Check for triple-dot ... notation, which is relative to that config's directory, not the CWD
+		if strings.Index(copies[i][0], "...") == 0 {
+			copies[i][0] = strings.Replace(copies[i][0], "...", cwd, 1)
+		}
+
+		abs, err := filepath.Abs(copies[i][0])
+		if err != nil { ExitGently("Cannot determine absolute path:", copies[i][0]) }
+		copies[i][0] = abs
+	}
+}
+
+//A copy of a container that can be changed without touching the original
+func (c Container) Copy() Container {
+	c.Command     = append(c.Command[:0:0], c.Command...)
+	c.Mounts      = copyLists(c.Mounts)
+	c.Ports       = copyLists(c.Ports)
+	c.DNS         = append(c.DNS[:0:0], c.DNS...)
+	c.Environment = copyLists(c.Environment)
+	c.Copies      = copyLists(c.Copies)
+	c.Artifacts   = copyLists(c.Artifacts)
+	c.Steps       = append(c.Steps[:0:0], c.Steps...)
+	for i := range c.Steps {
+		c.Steps[i].Command     = append(c.Steps[i].Command[:0:0], c.Steps[i].Command...)
+		c.Steps[i].Environment = copyLists(c.Steps[i].Environment)
+	}
+	return c
+}
+
+//The settings to run one of the container's steps with: its command, its folder if it has one, and its environment on top.
+func (c Container) ForStep(step Step) Container {
+	c = c.Copy()
+	c.Command = append([]string{}, step.Command...)
+	if step.Folder != "" {
+		c.Folder = step.Folder
+	}
+	for _, variable := range step.Environment {
+		found := false
+		for i := range c.Environment {
+			if c.Environment[i][0] == variable[0] {
+				c.Environment[i] = append([]string{}, variable...)
+				found = true
+			}
+		}
+		if !found {
+			c.Environment = append(c.Environment, append([]string{}, variable...))
+		}
+	}
+	c.Steps = nil
+	return c
+}
+
+func copyLists(lists [][]string) [][]string {
+	out := lists[:0:0]
+	for _, list := range lists {
+		out = append(out, append(list[:0:0], list...))
+	}
+	return out
 }
 
 //Default container
@@ -83,18 +301,42 @@ var DefaultContainer = Container {
 	Attach:      false,
 	Purge:       false,
 	Environment: [][]string{},
+	Copies:      [][]string{},
+	Artifacts:   [][]string{},
+	Steps:       []Step{},
 }
 
 //Hroot configuration
 type Configuration struct {
+	//Marks a project's top folder; folders above it aren't searched for configuration
+	Root     bool                 `toml:"root" json:"root" describe:"-"`
+
 	//The image struct
-	Image    Image                `toml:"image"`
+	Image    Image                `toml:"image" json:"image"`
 
 	//The settings struct
-	Settings Container            `toml:"settings"`
+	Settings Container            `toml:"settings" json:"settings"`
+
+	//The signing struct
+	Signing  Signing              `toml:"signing" json:"signing"`
+
+	//The graph struct
+	Graph    Graph                `toml:"graph" json:"graph"`
 
 	//A map of named targets, each representing another set of container settings
-	Targets  map[string]Container `toml:"target"`
+	Targets  map[string]Container `toml:"target" json:"target"`
+
+	//A map of named profiles, each changing settings and targets when it's selected
+	Profiles map[string]Profile   `toml:"profile" json:"profile" describe:"-"`
+}
+
+//Changes to settings and targets that only apply when a profile is selected
+type Profile struct {
+	//Goes on top of the settings from every file
+	Settings Container            `toml:"settings" json:"settings"`
+
+	//Goes on top of each target's keys from every file
+	Targets  map[string]Container `toml:"target" json:"target"`
 }
 
 //Default configuration
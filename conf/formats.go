@@ -0,0 +1,76 @@
+package conf
+
+import (
+	"path/filepath"
+)
+
+/*
+	A kind of config file.
+	Formats are found by file name, and every format's files are merged the same way, so they can be mixed across folders.
+*/
+type ConfigFormat interface {
+
+	//The name of config files in this format, like "hroot.toml".
+	FileName() string
+
+	//Decodes a file's data, and reports which keys it set.
+	Decode(data string) (*Configuration, KeySet, error)
+
+	//Finds where keys are in a file's data, so problems can be reported by line.
+	Locate(data string) Lines
+
+}
+
+//The keys a config file set, each as a path of names, like { "settings", "dns" }.
+type KeySet interface {
+
+	//Reports if a key was set; lists and tables count as set even if empty.
+	IsDefined(key ...string) bool
+
+	//Lists every key that was set, tables before the keys in them.
+	Keys() [][]string
+
+}
+
+//Line numbers of keys in a config file.
+type Lines interface {
+
+	//Returns the line of a key (a dotted path), or of one of its list entries if element isn't -1; zero if it can't be found.
+	Line(key string, element int) int
+
+}
+
+//Formats hroot knows, in the order they're looked for.
+var formats = []ConfigFormat{
+	TomlFormat{},
+	JSONFormat{},
+}
+
+//Adds a config file format.  Folders are searched for its files along with the built-in ones.
+func RegisterFormat(format ConfigFormat) {
+	formats = append(formats, format)
+}
+
+//The names of config files in every known format.
+func ConfigFileNames() []string {
+	names := []string{}
+	for _, format := range formats {
+		names = append(names, format.FileName())
+	}
+	return names
+}
+
+//Finds the format of a config file, by its name, or failing that its extension; nil if it isn't one hroot knows.
+func formatOf(file string) ConfigFormat {
+	for _, format := range formats {
+		if filepath.Base(file) == format.FileName() {
+			return format
+		}
+	}
+	for _, format := range formats {
+		if filepath.Ext(file) == filepath.Ext(format.FileName()) {
+			return format
+		}
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package conf
+
+import (
+	"errors"
+	"os"
+	"strings"
+	. "polydawn.net/pogo/gosh"
+)
+
+/*
+	Variables can be used in commands, steps, folders, mounts, environment, copies, artifacts, image names, and graph locations as "${NAME}",
+	or "${NAME:-default}" to fall back on a default when NAME isn't set.  "$$" is a literal "$".
+
+	NAME can be any variable from the host environment, or one of the built-ins, which take precedence:
+*/
+const (
+	//The folder of the hroot.toml the value is in
+	VarConfigDir   = "CONFIG_DIR"
+
+	//The highest folder with a hroot.toml; the same folder the graph defaults to being in
+	VarProjectRoot = "PROJECT_ROOT"
+
+	//The commit the project's git repo is on, if it's in one
+	VarGitCommit   = "GIT_COMMIT"
+
+	//The name of the target being run (not usable in image names or graph locations)
+	VarTarget      = "TARGET"
+
+	//The configured image name (not usable in image names or graph locations)
+	VarImageName   = "IMAGE_NAME"
+)
+
+/*
+	Looks up a variable.
+	Returns its value and true if it's set, false if it isn't;
+	or an error to fail on it, or deferred if it's one to leave alone for later.
+*/
+type lookupFunc func(name string) (value string, found bool, err error)
+
+//Returned by a lookupFunc for variables that can't be resolved yet
+var deferred = errors.New("deferred")
+
+/*
+	Expands the variables in a string.
+	Deferred variables are left as they are, and so are escaped dollars, unless this is the last pass.
+	Anything put in before the last pass has its dollars escaped, so that each value is only expanded once.
+*/
+func interpolate(s string, lookup lookupFunc, last bool) (string, error) {
+	out := ""
+	for {
+		i := strings.Index(s, "$")
+		if i < 0 || i == len(s)-1 {
+			return out + s, nil
+		}
+		out += s[:i]
+		s = s[i:]
+
+		switch s[1] {
+			case '$':
+				if last {
+					out += "$"
+				} else {
+					out += "$$"
+				}
+				s = s[2:]
+			case '{':
+				end := strings.Index(s, "}")
+				if end < 0 {
+					return "", errors.New("unterminated variable: " + s)
+				}
+				expr := s[2:end]
+				name, def, hasDefault := expr, "", false
+				if j := strings.Index(expr, ":-"); j >= 0 {
+					name, def, hasDefault = expr[:j], expr[j+2:], true
+				}
+				if name == "" {
+					return "", errors.New("empty variable name: " + s[:end+1])
+				}
+
+				value, found, err := lookup(name)
+				switch {
+					case err == deferred:
+						out += s[:end+1]
+					case err != nil:
+						return "", err
+					case found:
+						out += escapeDollars(value, last)
+					case hasDefault:
+						out += escapeDollars(def, last)
+					default:
+						return "", errors.New("variable " + name + " is not set")
+				}
+				s = s[end+1:]
+			default:
+				out += "$"
+				s = s[1:]
+		}
+	}
+}
+
+//Escapes the dollars in text put into a value, unless nothing is going to expand it again.
+func escapeDollars(s string, last bool) string {
+	if last {
+		return s
+	}
+	return strings.Replace(s, "$", "$$", -1)
+}
+
+//Looks a variable up in the host environment.
+func lookupEnv(name string) (string, bool) {
+	for _, pair := range os.Environ() {
+		if strings.HasPrefix(pair, name+"=") {
+			return pair[len(name)+1:], true
+		}
+	}
+	return "", false
+}
+
+//Asks git what commit a folder's repo is on.
+func gitCommit(dir string) (commit string, found bool) {
+	defer func() {
+		if recover() != nil {
+			commit, found = "", false
+		}
+	}()
+	commit = strings.TrimSpace(Sh("git")(NullIO)(Opts{Cwd: dir})("rev-parse", "HEAD").Output())
+	return commit, commit != ""
+}
+
+//Runs a function on each value of a container that can have variables, by key and list entry (-1 for plain values).
+func (c *Container) eachInterpolated(fn func(key string, element int, value string) string) {
+	for i := range c.Command {
+		c.Command[i] = fn("command", i, c.Command[i])
+	}
+	c.Folder = fn("folder", -1, c.Folder)
+	for i := range c.Mounts {
+		for j := range c.Mounts[i] {
+			c.Mounts[i][j] = fn("mounts", i, c.Mounts[i][j])
+		}
+	}
+	for i := range c.Environment {
+		for j := range c.Environment[i] {
+			c.Environment[i][j] = fn("environment", i, c.Environment[i][j])
+		}
+	}
+	eachCopyInterpolated(c.Copies, fn)
+	for i := range c.Artifacts {
+		for j := range c.Artifacts[i] {
+			c.Artifacts[i][j] = fn("artifacts", i, c.Artifacts[i][j])
+		}
+	}
+	for i := range c.Steps {
+		step := &c.Steps[i]
+		for j := range step.Command {
+			step.Command[j] = fn("step.command", i, step.Command[j])
+		}
+		step.Folder = fn("step.folder", i, step.Folder)
+		for j := range step.Environment {
+			for k := range step.Environment[j] {
+				step.Environment[j][k] = fn("step.environment", i, step.Environment[j][k])
+			}
+		}
+	}
+}
+
+//Runs a function on each value of an image that can have variables.
+func (img *Image) eachInterpolated(fn func(key string, element int, value string) string) {
+	img.Name = fn("name", -1, img.Name)
+	img.Upstream = fn("upstream", -1, img.Upstream)
+	img.Index = fn("index", -1, img.Index)
+	eachCopyInterpolated(img.Copies, fn)
+	for i := range img.From {
+		for j := range img.From[i] {
+			img.From[i][j] = fn("from", i, img.From[i][j])
+		}
+	}
+}
+
+//Runs a function on the paths of each copy; owners and modes are left alone.
+func eachCopyInterpolated(copies [][]string, fn func(key string, element int, value string) string) {
+	for i := range copies {
+		for j := 0; j < len(copies[i]) && j < 2; j++ {
+			copies[i][j] = fn("copy", i, copies[i][j])
+		}
+	}
+}
+
+//Runs a function on each location of a graph that can have variables.
+func (g *Graph) eachInterpolated(fn func(key string, element int, value string) string) {
+	g.Path = fn("path", -1, g.Path)
+	for name, remote := range g.Remotes {
+		g.Remotes[name] = fn("remotes."+name, -1, remote)
+	}
+}
@@ -0,0 +1,68 @@
+package conf
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+//Reads hroot.json files, which hold the same tables and keys as hroot.toml.
+type JSONFormat struct {}
+
+func (JSONFormat) FileName() string {
+	return "hroot.json"
+}
+
+func (JSONFormat) Decode(data string) (*Configuration, KeySet, error) {
+	var set Configuration
+	if err := json.Unmarshal([]byte(data), &set); err != nil {
+		return &set, jsonKeys{}, err
+	}
+
+	//Decode again loosely, to see which keys are there
+	var tree map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &tree); err != nil {
+		return &set, jsonKeys{}, err
+	}
+	keys := jsonKeys{}
+	keys.add(nil, tree)
+	return &set, keys, nil
+}
+
+func (JSONFormat) Locate(data string) Lines {
+	return locateJSON(data)
+}
+
+//The keys a JSON file set.
+type jsonKeys struct {
+	set  map[string]bool
+	list [][]string
+}
+
+func (k *jsonKeys) add(path []string, tree map[string]interface{}) {
+	if k.set == nil {
+		k.set = map[string]bool{}
+	}
+	names := []string{}
+	for name := range tree {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := tree[name]
+		key := append(append([]string{}, path...), name)
+		k.set[strings.Join(key, "\x00")] = true
+		k.list = append(k.list, key)
+		if table, ok := value.(map[string]interface{}); ok {
+			k.add(key, table)
+		}
+	}
+}
+
+func (k jsonKeys) IsDefined(key ...string) bool {
+	return k.set[strings.Join(key, "\x00")]
+}
+
+func (k jsonKeys) Keys() [][]string {
+	return k.list
+}
@@ -0,0 +1,130 @@
+package conf
+
+// Finds where keys are in JSON text, so problems can be reported by line.
+// Like the TOML pass, this is forgiving; the decoder reports anything malformed.
+
+import (
+	"strconv"
+	"strings"
+)
+
+func locateJSON(data string) keyLines {
+	s := &jsonScanner{ data: data, line: 1, lines: keyLines{} }
+	s.value(nil, true)
+	return s.lines
+}
+
+type jsonScanner struct {
+	data  string
+	pos   int
+	line  int
+	lines keyLines
+}
+
+func (s *jsonScanner) done() bool { return s.pos >= len(s.data) }
+func (s *jsonScanner) peek() byte { return s.data[s.pos] }
+
+func (s *jsonScanner) advance() {
+	if s.data[s.pos] == '\n' {
+		s.line++
+	}
+	s.pos++
+}
+
+func (s *jsonScanner) skipSpace() {
+	for !s.done() && strings.IndexByte(" \t\r\n", s.peek()) >= 0 {
+		s.advance()
+	}
+}
+
+//Skips over a value, noting the line of each key in it (if it's keyed), and of each entry of an array under a key.
+func (s *jsonScanner) value(path []string, keyed bool) {
+	s.skipSpace()
+	if s.done() {
+		return
+	}
+	switch s.peek() {
+		case '{':
+			s.advance()
+			for {
+				s.skipSpace()
+				if s.done() {
+					return
+				}
+				switch s.peek() {
+					case '}':
+						s.advance()
+						return
+					case ',':
+						s.advance()
+						continue
+					case '"':
+					default:
+						return
+				}
+				line := s.line
+				key := append(append([]string{}, path...), s.str())
+				if keyed {
+					s.lines[strings.Join(key, ".")] = line
+				}
+				s.skipSpace()
+				if !s.done() && s.peek() == ':' {
+					s.advance()
+				}
+				s.value(key, keyed)
+			}
+		case '[':
+			s.advance()
+			for n := 0; ; {
+				s.skipSpace()
+				if s.done() {
+					return
+				}
+				switch s.peek() {
+					case ']':
+						s.advance()
+						return
+					case ',':
+						s.advance()
+						continue
+				}
+				if keyed && path != nil {
+					s.lines[strings.Join(path, ".")+"#"+strconv.Itoa(n)] = s.line
+				}
+				n++
+				s.value(nil, false)
+			}
+		case '"':
+			s.str()
+		default:
+			start := s.pos
+			for !s.done() && strings.IndexByte(" \t\r\n,]}", s.peek()) < 0 {
+				s.advance()
+			}
+			if s.pos == start {
+				s.advance()
+			}
+	}
+}
+
+//Reads a string, returning it unquoted.
+func (s *jsonScanner) str() string {
+	start := s.pos
+	s.advance()
+	for !s.done() && s.peek() != '"' {
+		if s.peek() == '\\' {
+			s.advance()
+		}
+		if !s.done() {
+			s.advance()
+		}
+	}
+	if !s.done() {
+		s.advance()
+	}
+	unquoted, err := strconv.Unquote(s.data[start:s.pos])
+	if err != nil {
+		return s.data[start:s.pos]
+	}
+	return unquoted
+}
@@ -2,17 +2,33 @@ package conf
 
 import (
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"strings"
+	. "polydawn.net/hroot/util"
 )
 
 const ConfigFileName = "hroot.toml"
 
+//Folder of the configuration that applies everywhere on this machine, underneath any project's
+var SystemConfigDir = "/etc"
+
+//Folder of a user's own configuration, which goes underneath any project's but over the system's
+func UserConfigDir() string {
+	base := os.Getenv("XDG_CONFIG_HOME")
+	if base == "" {
+		base = filepath.Join(os.Getenv("HOME"), ".config")
+	}
+	return filepath.Join(base, "hroot")
+}
+
 //A generic interface for loading configuration.
-//Our implementation reads TOML files; roll your own!
+//Our implementation reads files of every registered ConfigFormat; roll your own!
 type ConfigParser interface {
 
 	//Parses a new configuration string.
 	//Each call should override configuration added before.
+	//Dir is the folder of the hroot.toml the string came from.
 	AddConfig(data, dir string) ConfigParser
 
 	//Called to get the final configuration after loading.
@@ -20,40 +36,156 @@ type ConfigParser interface {
 
 }
 
+//Extra hooks a ConfigParser can have; the loader uses them if it does.
+type ProjectConfigParser interface {
+	ConfigParser
+
+	//Parses a new configuration string, like AddConfig, from a file that needn't be a hroot.toml.
+	//File is the path of the file the string came from; its name says what format it's in.
+	AddConfigFile(data, file string) ConfigParser
+
+	//Checks a configuration string for problems, without loading it.
+	//File is the path of the file the string came from.
+	Validate(data, file string) []ConfigProblem
+
+	//Called before loading, with the project's top folder.
+	SetProjectRoot(dir string)
+
+	//Reports if a configuration string marks the project's top folder, so no folders above it are searched.
+	IsRoot(data, file string) bool
+
+}
+
 //Recursively finds configuration files & folders.
-func LoadConfigurationFromDisk(dir string, parser ConfigParser) (*Configuration, *Folders) {
+//If file is set, it's used instead of searching for project configuration.
+func LoadConfigurationFromDisk(dir, file string, parser ConfigParser) (*Configuration, *Folders) {
+	files, paths, folders := findConfiguration(dir, file, parser)
+
+	//Unroll data - we discovered them in reverse order, send each to parser
+	for n := len(files) - 1; n >= 0; n-- {
+		addConfig(parser, files[n], paths[n])
+	}
+
+	//A configured graph beats the default one
+	configuration := parser.GetConfig()
+	if configuration.Graph.Path != "" {
+		folders.Graph = configuration.Graph.Path
+	}
+
+	return configuration, folders
+}
+
+//Recursively finds configuration files, and checks each for problems.
+func CheckConfigurationOnDisk(dir, file string, parser ConfigParser) []ConfigProblem {
+	files, paths, _ := findConfiguration(dir, file, parser)
+
+	//A parser that can't check files can only load them, and refuse what it can't use
+	project, ok := parser.(ProjectConfigParser)
+	problems := []ConfigProblem{}
+	for n := len(files) - 1; n >= 0; n-- {
+		if ok {
+			problems = append(problems, project.Validate(files[n], paths[n])...)
+		} else {
+			addConfig(parser, files[n], paths[n])
+		}
+	}
+	return problems
+}
+
+//Sends a config file to the parser, by its path if the parser takes one, otherwise by its folder.
+func addConfig(parser ConfigParser, data, path string) {
+	if project, ok := parser.(ProjectConfigParser); ok {
+		project.AddConfigFile(data, path)
+	} else {
+		parser.AddConfig(data, filepath.Dir(path))
+	}
+}
+
+/*
+	Finds configuration files, deepest first, with their paths:
+	the project's, from a folder upwards until one is marked as the root or doesn't have one,
+	then the user's and the system's.
+	Tells the parser where the project's top folder is.
+*/
+func findConfiguration(dir, file string, parser ConfigParser) ([]string, []string, *Folders) {
 	//Default settings, folders, and parsed data
 	folders := DefaultFolders(dir)
 	files := []string{}
-	dirs  := []string{}
+	paths := []string{}
+	root  := dir
 
-	//Recurse up the file tree looking for configuration
-	for {
+	if file != "" {
+		//Use just the file we were given; its folder stands in for the project's
+		if formatOf(file) == nil {
+			ExitGently("Cannot tell what format", file, "is in; config files are named like", strings.Join(ConfigFileNames(), " or "))
+		}
+		buf, err := ioutil.ReadFile(file)
+		if err != nil {
+			ExitGently("Could not read config file", file + ":", err)
+		}
+		root = filepath.Dir(file)
+		folders.Graph = filepath.Join(root, GraphFolder)
+		files = append(files, string(buf))
+		paths = append(paths, file)
+	} else {
+		//Recurse up the file tree looking for configuration
+		for {
 
-		//Try to read toml file
-		buf, err := ioutil.ReadFile(dir + "/" + ConfigFileName)
+			//Try to read a config file
+			data, path, found := readConfigFile(dir)
 
-		//Did we succeed?
-		if err == nil {
-			//Default graph folder is a child of the highest folder that has configuration
-			folders.Graph = filepath.Join(dir, GraphFolder)
+			//Did we succeed?
+			if found {
+				//Default graph folder is a child of the highest folder that has configuration
+				root = dir
+				folders.Graph = filepath.Join(dir, GraphFolder)
 
-			//Convert data to a string, save for later
-			data := string(buf)
-			files = append(files, data)
-			dirs  = append(dirs, dir)
+				//Save for later
+				files = append(files, data)
+				paths = append(paths, path)
 
-			//Increment folder for next stage
-			dir = filepath.Join("../", dir)
-		} else {
-			break //If the file was not readable, done loading config
+				//Stop at the project's root, if it says where that is
+				if project, ok := parser.(ProjectConfigParser); ok && project.IsRoot(data, path) {
+					break
+				}
+
+				//Increment folder for next stage, unless there's nowhere left to go
+				parent := filepath.Join(dir, "..")
+				if absPath(parent) == absPath(dir) {
+					break
+				}
+				dir = parent
+			} else {
+				break //If the file was not readable, done loading config
+			}
 		}
 	}
 
-	//Unroll data - we discovered them in reverse order, send each to parser
-	for n := len(files) - 1; n >= 0; n-- {
-		parser.AddConfig(files[n], dirs[n])
+	//Personal configuration goes underneath
+	for _, personal := range []string{ UserConfigDir(), SystemConfigDir } {
+		if data, path, found := readConfigFile(personal); found {
+			files = append(files, data)
+			paths = append(paths, path)
+		}
 	}
 
-	return parser.GetConfig(), folders
+	if project, ok := parser.(ProjectConfigParser); ok {
+		project.SetProjectRoot(root)
+	}
+	return files, paths, folders
+}
+
+//Reads the config file in a folder, in whichever format it's in.  Exits if there's more than one.
+func readConfigFile(dir string) (data, path string, found bool) {
+	for _, name := range ConfigFileNames() {
+		buf, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		if found {
+			ExitGently("Found both", filepath.Base(path), "and", name, "in", absPath(dir) + "; hroot needs just one.")
+		}
+		data, path, found = string(buf), filepath.Join(dir, name), true
+	}
+	return data, path, found
 }
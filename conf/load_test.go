@@ -0,0 +1,107 @@
+package conf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"github.com/coocood/assrt"
+)
+
+func TestLoadLayers(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	//A machine with system and user configuration, and a project nested in another folder with configuration
+	base, err := ioutil.TempDir("", "hroot-conf-")
+	if err != nil { panic(err); }
+	defer os.RemoveAll(base)
+	write := func(file, data string) {
+		os.MkdirAll(filepath.Dir(file), 0755)
+		if err := ioutil.WriteFile(file, []byte(data), 0644); err != nil { panic(err); }
+	}
+
+	defer func(system, home, xdg string) {
+		SystemConfigDir = system
+		os.Setenv("HOME", home)
+		os.Setenv("XDG_CONFIG_HOME", xdg)
+	}(SystemConfigDir, os.Getenv("HOME"), os.Getenv("XDG_CONFIG_HOME"))
+	SystemConfigDir = filepath.Join(base, "etc")
+	os.Setenv("HOME", filepath.Join(base, "home"))
+	os.Setenv("XDG_CONFIG_HOME", "")
+
+	write(filepath.Join(SystemConfigDir, ConfigFileName), "[settings]\n\tdns = [ \"8.8.8.8\" ]\n\tfolder = \"/system\"\n")
+	write(filepath.Join(base, "home", ".config", "hroot", ConfigFileName), "[settings]\n\tfolder = \"/user\"\n")
+	write(filepath.Join(base, "outside", ConfigFileName), "[settings]\n\tdns = [ \"9.9.9.9\" ]\n")
+	write(filepath.Join(base, "outside", "project", ConfigFileName), "root = true\n\n[settings]\n\tprivileged = true\n")
+	write(filepath.Join(base, "outside", "project", "sub", ConfigFileName), "[settings]\n\tattach = true\n")
+	project := filepath.Join(base, "outside", "project")
+
+	//Personal layers go underneath, and the walk stops at the root
+	conf, folders := LoadConfigurationFromDisk(filepath.Join(project, "sub"), "", &FileConfigParser{})
+	assert.Equal("/user", conf.Settings.Folder)
+	assert.Equal([]string{ "8.8.8.8" }, conf.Settings.DNS)
+	assert.True(conf.Settings.Privileged)
+	assert.True(conf.Settings.Attach)
+	assert.Equal(filepath.Join(project, GraphFolder), folders.Graph)
+
+	//An explicit file replaces the project's files, but not the personal ones
+	custom := filepath.Join(base, "elsewhere", "custom.toml")
+	write(custom, "[settings]\n\tfolder = \"/custom\"\n")
+	parser := &FileConfigParser{}
+	conf, folders = LoadConfigurationFromDisk(filepath.Join(project, "sub"), custom, parser)
+	assert.Equal("/custom", conf.Settings.Folder)
+	assert.False(conf.Settings.Attach)
+	assert.Equal([]string{ "8.8.8.8" }, conf.Settings.DNS)
+	assert.Equal(filepath.Join(base, "elsewhere", GraphFolder), folders.Graph)
+	assert.Equal([]string{ custom }, parser.GetProvenance()["settings.folder"])
+
+	//A parser without the project hooks still loads, it just can't say where the project stops
+	conf, _ = LoadConfigurationFromDisk(filepath.Join(project, "sub"), "", &plainParser{})
+	assert.True(conf.Settings.Attach)
+	assert.Equal([]string{ "8.8.8.8", "9.9.9.9" }, conf.Settings.DNS)
+}
+
+//Only the basic ConfigParser methods, like a parser written before the hooks were
+type plainParser struct {
+	parser FileConfigParser
+}
+
+func (p *plainParser) AddConfig(data, dir string) ConfigParser {
+	p.parser.AddConfig(data, dir)
+	return p
+}
+
+func (p *plainParser) GetConfig() *Configuration {
+	return p.parser.GetConfig()
+}
+
+func TestLoadMixedFormats(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	base, err := ioutil.TempDir("", "hroot-conf-")
+	if err != nil { panic(err); }
+	defer os.RemoveAll(base)
+	defer func(system, xdg string) {
+		SystemConfigDir = system
+		os.Setenv("XDG_CONFIG_HOME", xdg)
+	}(SystemConfigDir, os.Getenv("XDG_CONFIG_HOME"))
+	SystemConfigDir = filepath.Join(base, "etc")
+	os.Setenv("XDG_CONFIG_HOME", filepath.Join(base, "home"))
+
+	project := filepath.Join(base, "project")
+	os.MkdirAll(filepath.Join(project, "sub"), 0755)
+	ioutil.WriteFile(filepath.Join(project, ConfigFileName), []byte("root = true\n\n[settings]\n\tdns = [ \"8.8.8.8\" ]\n"), 0644)
+	ioutil.WriteFile(filepath.Join(project, "sub", "hroot.json"), []byte(`{ "settings": { "dns": [ "8.8.4.4" ] } }`), 0644)
+
+	conf, _ := LoadConfigurationFromDisk(filepath.Join(project, "sub"), "", &FileConfigParser{})
+	assert.Equal([]string{ "8.8.8.8", "8.8.4.4" }, conf.Settings.DNS)
+
+	//One folder can't have two
+	ioutil.WriteFile(filepath.Join(project, "sub", ConfigFileName), []byte(""), 0644)
+	defer func() {
+		if recover() == nil {
+			t.Fail()
+		}
+	}()
+	LoadConfigurationFromDisk(filepath.Join(project, "sub"), "", &FileConfigParser{})
+}
@@ -0,0 +1,119 @@
+package conf
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/*
+	Changes one of a container's settings, from a value written the way a command line would have it.
+	Single values are replaced, and command is split on spaces (and replaces any steps).
+	Lists get the value as one more entry: mounts as "host:container[:ro|rw]", ports as "host:container",
+	and environment as "NAME=value", which overrides a variable of the same name.
+*/
+func (c *Container) Set(key, value string) error {
+	switch key {
+		case "command":
+			//Just like in a config file, a command takes over from steps
+			c.Command = strings.Fields(value)
+			c.Steps = []Step{}
+		case "folder":
+			c.Folder = value
+		case "privileged", "attach", "purge":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return errors.New(key + " must be true or false, not " + strconv.Quote(value))
+			}
+			switch key {
+				case "privileged": c.Privileged = b
+				case "attach":     c.Attach = b
+				case "purge":      c.Purge = b
+			}
+		case "mounts":
+			mount, err := ParseMount(value)
+			if err != nil { return err }
+			c.Mounts = append(c.Mounts, mount)
+		case "ports":
+			port, err := ParsePort(value)
+			if err != nil { return err }
+			c.Ports = append(c.Ports, port)
+		case "dns":
+			c.DNS = append(c.DNS, value)
+		case "environment":
+			variable, err := ParseEnvironment(value)
+			if err != nil { return err }
+			for i := range c.Environment {
+				if c.Environment[i][0] == variable[0] {
+					c.Environment[i] = variable
+					return nil
+				}
+			}
+			c.Environment = append(c.Environment, variable)
+		default:
+			return errors.New("no setting named " + strconv.Quote(key) + " can be set")
+	}
+	return nil
+}
+
+//Parses a mount written as "host:container[:ro|rw]", read-write unless it says otherwise.  The host folder is made absolute.
+func ParseMount(value string) ([]string, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) == 2 {
+		parts = append(parts, "rw")
+	}
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+		return nil, errors.New("a mount is written host:container[:ro|rw], not " + strconv.Quote(value))
+	}
+	if parts[2] != "ro" && parts[2] != "rw" {
+		return nil, errors.New("a mount must be \"ro\" or \"rw\", not " + strconv.Quote(parts[2]))
+	}
+	abs, err := filepath.Abs(parts[0])
+	if err != nil { return nil, err }
+	return []string{ abs, parts[1], parts[2] }, nil
+}
+
+//Parses a port written as "host:container".
+func ParsePort(value string) ([]string, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, errors.New("a port is written host:container, not " + strconv.Quote(value))
+	}
+	return parts, nil
+}
+
+//Parses an environment variable written as "NAME=value".
+func ParseEnvironment(value string) ([]string, error) {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return nil, errors.New("an environment variable is written NAME=value, not " + strconv.Quote(value))
+	}
+	return parts, nil
+}
+
+//Parses a copy's owner, written as "uid" or "uid:gid"; the group is root's unless it's given.
+func ParseOwner(value string) (uid int, gid int, err error) {
+	parts := strings.Split(value, ":")
+	if len(parts) > 2 {
+		return 0, 0, errors.New("an owner is written uid[:gid], not " + strconv.Quote(value))
+	}
+	uid, err = strconv.Atoi(parts[0])
+	if err == nil && len(parts) == 2 {
+		gid, err = strconv.Atoi(parts[1])
+	}
+	if err != nil || uid < 0 || gid < 0 {
+		return 0, 0, errors.New("an owner must be a numeric uid[:gid], not " + strconv.Quote(value))
+	}
+	return uid, gid, nil
+}
+
+//Parses a copy's mode, written in octal like "0644".
+func ParseMode(value string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, errors.New("a mode must be octal permissions like \"0644\", not " + strconv.Quote(value))
+	}
+	return os.FileMode(mode), nil
+}
@@ -0,0 +1,58 @@
+package conf
+
+import (
+	"path/filepath"
+	"testing"
+	"github.com/coocood/assrt"
+)
+
+func TestContainerSet(t *testing.T) {
+	assert := assrt.NewAssert(t)
+	cwd, _ := filepath.Abs(".")
+
+	c := DefaultContainer.Copy()
+	c.Environment = [][]string{ []string{ "LANG", "C" } }
+	c.Steps = []Step{ Step{ Command: []string{ "./configure" } } }
+
+	assert.Nil(c.Set("folder", "/src"))
+	assert.Nil(c.Set("command", "make all"))
+	assert.Nil(c.Set("privileged", "true"))
+	assert.Nil(c.Set("mounts", "cache:/cache"))
+	assert.Nil(c.Set("mounts", "/data:/data:ro"))
+	assert.Nil(c.Set("ports", "8080:80"))
+	assert.Nil(c.Set("dns", "8.8.8.8"))
+	assert.Nil(c.Set("environment", "LANG=en_US.UTF-8"))
+	assert.Nil(c.Set("environment", "DEBUG=1=2"))
+
+	assert.Equal("/src", c.Folder)
+	assert.Equal([]string{ "make", "all" }, c.Command)
+	assert.Equal(0, len(c.Steps))
+	assert.True(c.Privileged)
+	assert.Equal(
+		[][]string{
+			[]string{ filepath.Join(cwd, "cache"), "/cache", "rw" },
+			[]string{ "/data", "/data", "ro" },
+		},
+		c.Mounts,
+	)
+	assert.Equal([][]string{ []string{ "8080", "80" } }, c.Ports)
+	assert.Equal([]string{ "8.8.8.8" }, c.DNS)
+	assert.Equal(
+		[][]string{
+			[]string{ "LANG", "en_US.UTF-8" },
+			[]string{ "DEBUG", "1=2" },
+		},
+		c.Environment,
+	)
+
+	// nonsense is refused
+	assert.NotNil(c.Set("privileged", "maybe"))
+	assert.NotNil(c.Set("mounts", "/data"))
+	assert.NotNil(c.Set("mounts", "/data:/data:rx"))
+	assert.NotNil(c.Set("ports", "80"))
+	assert.NotNil(c.Set("environment", "=1"))
+	assert.NotNil(c.Set("image", "ubuntu"))
+
+	// and the defaults are untouched
+	assert.Equal(0, len(DefaultContainer.Mounts))
+}
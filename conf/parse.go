@@ -1,112 +1,625 @@
 package conf
 
-// The only file that imports toml.
-// Keeps our chosen file format isolated from the rest of the system.
+// Merges config files of any format; the formats themselves only decode.
 
 import (
+	"errors"
 	"path/filepath"
-	"github.com/BurntSushi/toml"
+	"reflect"
+	"strings"
 	. "polydawn.net/hroot/util"
 )
 
-type TomlConfigParser struct {
+//Loads config files of every registered format, each overriding the ones before.
+type FileConfigParser struct {
 	config *Configuration
+	provenance Provenance
+
+	//Settings from every file, before any profile, and where they came from
+	settings           Container
+	settingsProvenance Provenance
+
+	//Every file's settings for each target, and each profile's sections, shallowest first; merged by GetConfig
+	targets  map[string][]targetLayer
+	profiles map[string][]profileLayer
+
+	//The profile to merge on top, if any
+	profile string
+
+	//Problems found in the files that weren't bad enough to stop loading them
+	warnings []ConfigProblem
+
+	//Top folder of the project (by default, the folder of the first config file), and the commit its git repo is on (looked up when first needed)
+	root      string
+	gitCommit *string
+}
+
+//Deprecated: the parser reads more than TOML now; use FileConfigParser.
+type TomlConfigParser = FileConfigParser
+
+//One file's settings for a target
+type targetLayer struct {
+	container Container
+	meta      KeySet
+	trace     *Trace
+
+	//Where the target is in the file
+	key       []string
+}
+
+//One file's sections for a profile
+type profileLayer struct {
+	profile Profile
+	meta    KeySet
+	trace   *Trace
+}
+
+func (p *FileConfigParser) AddConfig(data, dir string) ConfigParser {
+	return p.AddConfigFile(data, filepath.Join(dir, ConfigFileName))
 }
 
-func (p *TomlConfigParser) AddConfig(data, dir string) ConfigParser {
+func (p *FileConfigParser) AddConfigFile(data, file string) ConfigParser {
+	dir, file, format := configSource(file)
+
 	//Load default configuration if no previous data
 	if p.config == nil {
 		a := DefaultConfiguration
 		p.config = &a
+		p.provenance = Provenance{}
+		p.settings = DefaultContainer
+		p.settingsProvenance = Provenance{}
+		p.targets = map[string][]targetLayer{}
+		p.profiles = map[string][]profileLayer{}
+	}
+
+	//The first file in is the highest one up
+	if p.root == "" {
+		p.root = absPath(dir)
 	}
 
-	//Parse toml, expand relative paths, and override settings
-	conf, meta := ParseString(data)
+	//Values are traced back to the file they came from
+	trace := &Trace{
+		Provenance: p.provenance,
+		Source:     file,
+	}
+
+	//Refuse anything we can't use, rather than crash on it later; the rest is kept for the caller to mention
+	fatal := []string{}
+	for _, problem := range p.Validate(data, file) {
+		if problem.Fatal {
+			fatal = append(fatal, problem.String())
+		} else {
+			p.warnings = append(p.warnings, problem)
+		}
+	}
+	if len(fatal) > 0 {
+		ExitGently("Problems in configuration:\n" + strings.Join(fatal, "\n"))
+	}
+
+	//Parse the file, expand variables and relative paths, and override settings
+	conf, meta, err := format.Decode(data)
+	if err != nil { ExitGently("Could not decode file:", err) }
+	problems := []string{}
+	for _, problem := range p.interpolateFile(conf, dir) {
+		problems = append(problems, problem.String())
+	}
+	if len(problems) > 0 {
+		ExitGently("Problems in configuration:\n" + strings.Join(problems, "\n"))
+	}
 	conf.Settings.Localize(dir)
-	LoadContainerSettings(&p.config.Settings, &conf.Settings, meta, "settings")
+	settingsTrace := &Trace{ Provenance: p.settingsProvenance, Source: file }
+	LoadContainerSettings(&p.settings, &conf.Settings, meta, settingsTrace.Under("settings"), "settings")
 
 	//Load image names
+	conf.Image.Localize(dir)
 	p.config.Image = conf.Image
+	p.provenance.Clear("image")
+	for _, key := range [][]string{ {"image", "name"}, {"image", "upstream"}, {"image", "index"}, {"image", "prune", "keep"}, {"image", "prune", "before"} } {
+		if meta.IsDefined(key...) {
+			trace.Set(key...)
+		}
+	}
+	trace.Add(len(conf.Image.Copies), "image", "copy")
+	trace.Add(len(conf.Image.From), "image", "from")
 
-	//If image keys 'upstream' and 'index' are defined, reject.
-	if meta.IsDefined("image", "upstream") && meta.IsDefined("image", "index") {
-		//Try to report absolute directory
-		absDir, err := filepath.Abs(dir)
-		if err == nil { dir = absDir }
+	//Load signing settings
+	conf.Signing.Localize(dir)
+	if meta.IsDefined("signing", "key") {
+		p.config.Signing.Key = conf.Signing.Key
+		trace.Set("signing", "key")
+	}
+	if meta.IsDefined("signing", "keyring") {
+		p.config.Signing.Keyring = conf.Signing.Keyring
+		trace.Set("signing", "keyring")
+	}
 
-		ExitGently("In", dir, ": Cannot define 'index' and 'upstream' in the same file. \nUse separate config files to produce different images.")
+	//Load graph settings
+	conf.Graph.Localize(dir)
+	if meta.IsDefined("graph", "path") {
+		p.config.Graph.Path = conf.Graph.Path
+		trace.Set("graph", "path")
+	}
+	for name, remote := range conf.Graph.Remotes {
+		if p.config.Graph.Remotes == nil {
+			p.config.Graph.Remotes = map[string]string{}
+		}
+		p.config.Graph.Remotes[name] = remote
+		trace.Set("graph", "remotes", name)
 	}
 
-	//Load any target settings
-	p.config.Targets = conf.Targets
+	//Save target settings; they're merged once every file is in
+	for x, target := range conf.Targets {
+		target.Localize(dir)
+		p.targets[x] = append(p.targets[x], targetLayer{
+			container: target,
+			meta:      meta,
+			trace:     trace.Under("target", x),
+			key:       []string{ "target", x },
+		})
+	}
 
-	for x := range p.config.Targets {
-		a := p.config.Settings
-		b := p.config.Targets[x]
-		LoadContainerSettings(&a, &b, meta, "target", x)
-		p.config.Targets[x] = a
+	//Save profiles too; only the selected one is merged
+	for name, profile := range conf.Profiles {
+		profile.Settings.Localize(dir)
+		for x, target := range profile.Targets {
+			target.Localize(dir)
+			profile.Targets[x] = target
+		}
+		p.profiles[name] = append(p.profiles[name], profileLayer{
+			profile: profile,
+			meta:    meta,
+			trace:   trace,
+		})
 	}
 
 	//Chain calls
 	return p
 }
 
-func (p *TomlConfigParser) GetConfig() *Configuration {
+func (p *FileConfigParser) GetConfig() *Configuration {
 	if p.config == nil {
+		if p.profile != "" {
+			ExitGently("No profile named", p.profile, "is configured.")
+		}
 		return &DefaultConfiguration
 	} else {
+		p.merge()
 		return p.config
 	}
 }
 
-//Parse a TOML-formatted string into a configuration struct.
-func ParseString(data string) (*Configuration, *toml.MetaData) {
-	var set Configuration
+//Selects a profile to merge on top of every file's settings and targets.
+func (p *FileConfigParser) UseProfile(name string) {
+	p.profile = name
+}
 
-	//Decode the file
-	md, err := toml.Decode(data, &set)
-	if err != nil { ExitGently("Could not decode file:", err) }
+/*
+	Works out the settings, and each target's settings, from every file.
+	The selected profile's settings go on top of the settings from every file.
+	A target starts from those, or from the target it extends, and then takes the target's own keys from each file in turn, then from the profile.
+	Target keys beat settings keys, no matter which files they're in.
+*/
+func (p *FileConfigParser) merge() {
+	profile := p.profiles[p.profile]
+	if p.profile != "" && len(profile) == 0 {
+		ExitGently("No profile named", p.profile, "is configured.")
+	}
+
+	p.config.Settings = p.settings.Copy()
+	p.provenance.Clear("settings")
+	for key, sources := range p.settingsProvenance {
+		p.provenance[key] = append([]string{}, sources...)
+	}
+	for _, layer := range profile {
+		LoadContainerSettings(&p.config.Settings, &layer.profile.Settings, layer.meta, layer.trace.Under("settings"), "profile", p.profile, "settings")
+	}
+
+	//The profile's targets go after everyone else's
+	targets := map[string][]targetLayer{}
+	for x, layers := range p.targets {
+		targets[x] = layers[:len(layers):len(layers)]
+	}
+	for _, layer := range profile {
+		for x, target := range layer.profile.Targets {
+			targets[x] = append(targets[x], targetLayer{
+				container: target,
+				meta:      layer.meta,
+				trace:     layer.trace.Under("target", x),
+				key:       []string{ "profile", p.profile, "target", x },
+			})
+		}
+	}
+
+	if len(targets) == 0 {
+		return
+	}
+	p.config.Targets = map[string]Container{}
+	p.provenance.Clear("target")
+
+	merged := map[string]Container{}
+	var merge func(x string, chain []string)
+	merge = func(x string, chain []string) {
+		if _, done := merged[x]; done {
+			return
+		}
+		for _, y := range chain {
+			if y == x {
+				ExitGently("Targets extend each other in a loop:", strings.Join(append(chain, x), " -> "))
+			}
+		}
+
+		//The last file to say what this target extends wins
+		extends := ""
+		for _, layer := range targets[x] {
+			if layer.meta.IsDefined(append(layer.key, "extends")...) {
+				extends = layer.container.Extends
+			}
+		}
+
+		base := p.config.Settings.Copy()
+		p.provenance.Copy("settings", "target."+x)
+		if extends != "" {
+			if _, ok := targets[extends]; !ok {
+				ExitGently("Target", x, "extends", extends, "but there is no target named", extends)
+			}
+			merge(extends, append(chain, x))
+			base = merged[extends].Copy()
+			p.provenance.Clear("target."+x)
+			p.provenance.Copy("target."+extends, "target."+x)
+		}
+
+		for _, layer := range targets[x] {
+			LoadContainerSettings(&base, &layer.container, layer.meta, layer.trace, layer.key...)
+		}
+		merged[x] = base
+	}
+	for x := range targets {
+		merge(x, nil)
+	}
+
+	//Now that each target is whole, it can know its own name
+	for x := range merged {
+		target := merged[x].Copy()
+		target.eachInterpolated(func(key string, element int, value string) string {
+			value, err := interpolate(value, p.targetVariables(x), true)
+			if err != nil {
+				ExitGently("In target", x + ":", key + ":", err)
+			}
+			return value
+		})
+		p.config.Targets[x] = target
+	}
+}
+
+/*
+	Expands variables in the values from one config file.
+	TARGET and IMAGE_NAME are left for when targets are merged, except outside of settings and targets, where they're refused.
+	Returns a problem for each value that can't be expanded.
+*/
+func (p *FileConfigParser) interpolateFile(c *Configuration, dir string) []ConfigProblem {
+	problems := []ConfigProblem{}
+	expand := func(prefix string, untargeted bool) func(string, int, string) string {
+		return func(key string, element int, value string) string {
+			expanded, err := interpolate(value, p.fileVariables(dir, untargeted), untargeted)
+			if err != nil {
+				problems = append(problems, ConfigProblem{ Key: prefix+"."+key, Element: element, Message: err.Error(), Fatal: true })
+				return value
+			}
+			return expanded
+		}
+	}
+
+	c.Image.eachInterpolated(expand("image", true))
+	c.Graph.eachInterpolated(expand("graph", true))
+	c.Settings.eachInterpolated(expand("settings", false))
+	for x, target := range c.Targets {
+		target.eachInterpolated(expand("target."+x, false))
+		c.Targets[x] = target
+	}
+	for name, profile := range c.Profiles {
+		profile.Settings.eachInterpolated(expand("profile."+name+".settings", false))
+		for x, target := range profile.Targets {
+			target.eachInterpolated(expand("profile."+name+".target."+x, false))
+			profile.Targets[x] = target
+		}
+		c.Profiles[name] = profile
+	}
+	return problems
+}
+
+//Variables for values in the config file in a folder.
+func (p *FileConfigParser) fileVariables(dir string, untargeted bool) lookupFunc {
+	return func(name string) (string, bool, error) {
+		switch name {
+			case VarConfigDir:
+				return absPath(dir), true, nil
+			case VarProjectRoot:
+				return p.root, true, nil
+			case VarGitCommit:
+				if p.gitCommit == nil {
+					commit, _ := gitCommit(p.root)
+					p.gitCommit = &commit
+				}
+				return *p.gitCommit, *p.gitCommit != "", nil
+			case VarTarget, VarImageName:
+				if untargeted {
+					return "", false, errors.New(name + " can only be used in settings and targets")
+				}
+				return "", false, deferred
+		}
+		value, found := lookupEnv(name)
+		return value, found, nil
+	}
+}
+
+//Variables that wait until a target is merged.
+func (p *FileConfigParser) targetVariables(target string) lookupFunc {
+	return func(name string) (string, bool, error) {
+		switch name {
+			case VarTarget:
+				return target, true, nil
+			case VarImageName:
+				return p.config.Image.Name, p.config.Image.Name != "", nil
+		}
+		value, found := lookupEnv(name)
+		return value, found, nil
+	}
+}
+
+/*
+	Checks a config string for problems, without loading it.
+	Values hroot can't use are fatal; keys hroot doesn't know are warnings.
+	Problems are reported against the given file, by line where possible.
+*/
+func (p *FileConfigParser) Validate(data, file string) []ConfigProblem {
+	dir, file, format := configSource(file)
+	lines := format.Locate(data)
+
+	if p.root == "" {
+		p.root = absPath(dir)
+	}
+
+	set, meta, err := format.Decode(data)
+	if err != nil {
+		return []ConfigProblem{{ File: file, Element: -1, Message: "could not decode file: " + err.Error(), Fatal: true }}
+	}
+
+	problems := p.interpolateFile(set, dir)
+	problems = append(problems, validateConfiguration(set)...)
+	for _, key := range meta.Keys() {
+		if !knownKey(reflect.TypeOf(*set), key) {
+			problems = append(problems, ConfigProblem{
+				Key:     strings.Join(key, "."),
+				Element: -1,
+				Message: "unknown key",
+			})
+		}
+	}
+
+	for i := range problems {
+		problems[i].File = file
+		problems[i].Line = lines.Line(problems[i].Key, problems[i].Element)
+	}
+	return problems
+}
+
+//Checks that a key is one the configuration structs have a place for.
+func knownKey(t reflect.Type, key []string) bool {
+	for _, name := range key {
+		//Arrays of tables have the same keys in each table
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Struct {
+			t = t.Elem()
+		}
+		switch t.Kind() {
+			case reflect.Struct:
+				found := false
+				for i := 0; i < t.NumField(); i++ {
+					if strings.Split(t.Field(i).Tag.Get("toml"), ",")[0] == name {
+						t, found = t.Field(i).Type, true
+						break
+					}
+				}
+				if !found { return false }
+			case reflect.Map:
+				t = t.Elem()
+			default:
+				return false
+		}
+	}
+	return true
+}
+
+//Problems in the files loaded so far that didn't stop them loading, like unknown keys.
+func (p *FileConfigParser) Warnings() []ConfigProblem {
+	return p.warnings
+}
+
+//Which file each value in the configuration came from.
+func (p *FileConfigParser) GetProvenance() Provenance {
+	if p.provenance == nil {
+		return Provenance{}
+	}
+	p.merge()
+	return p.provenance
+}
+
+func (p *FileConfigParser) SetProjectRoot(dir string) {
+	p.root = absPath(dir)
+}
 
-	return &set, &md
+//Checks for "root = true" at the top of a file.
+func (p *FileConfigParser) IsRoot(data, file string) bool {
+	_, _, format := configSource(file)
+	set, _, err := format.Decode(data)
+	return err == nil && set.Root
+}
+
+//Splits a config file's path into its folder, its absolute path for reporting, and its format.
+func configSource(file string) (string, string, ConfigFormat) {
+	format := formatOf(file)
+	if format == nil {
+		ExitGently("Cannot tell what format", file, "is in; config files are named like", strings.Join(ConfigFileNames(), " or "))
+	}
+	return filepath.Dir(file), absPath(file), format
+}
+
+//Absolute form of a config folder, for reporting.  Falls back to the folder as given.
+func absPath(dir string) string {
+	abs, err := filepath.Abs(dir)
+	if err != nil { return dir }
+	return abs
 }
 
 //Loads a container configuration object, overriding a base
-//This function prevents empty TOML keys (anything you didn't specify) from overriding a preset value.
-func LoadContainerSettings(base *Container, inc *Container, meta *toml.MetaData, key ...string) {
+//This function prevents empty keys (anything you didn't specify) from overriding a preset value.
+//Lists are added to, unless the incoming config replaces them or removes entries from them; environment variables override by name.
+//Each value taken is recorded with the trace, which may be nil.
+func LoadContainerSettings(base *Container, inc *Container, meta KeySet, trace *Trace, key ...string) {
+	replace := map[string]bool{}
+	for _, list := range inc.Replace {
+		replace[list] = true
+	}
+
+	if meta.IsDefined(append(key, "extends")...) {
+		base.Extends = inc.Extends
+		trace.Set("extends")
+	}
 
+	//A target runs a command or steps, whichever the latest file gave it
 	if meta.IsDefined(append(key, "command")...) {
 		base.Command = inc.Command
+		trace.Set("command")
+		base.Steps = []Step{}
+		trace.Reset(0, "step")
 	}
 
 	if meta.IsDefined(append(key, "folder")...) {
 		base.Folder = inc.Folder
+		trace.Set("folder")
+	}
+
+	//Steps are a sequence, so a file that has any replaces the inherited ones whole
+	if meta.IsDefined(append(key, "step")...) {
+		base.Steps = inc.Steps
+		trace.Set("step")
+		base.Command = []string{}
+		trace.Reset(0, "command")
 	}
 
 	if meta.IsDefined(append(key, "privileged")...) {
 		base.Privileged = inc.Privileged
+		trace.Set("privileged")
 	}
 
-	if meta.IsDefined(append(key, "mounts")...) {
+	//Drop inherited entries first, so the incoming config can add them back
+	if meta.IsDefined(append(key, "remove")...) {
+		base.Mounts = removeEntries(base.Mounts, 1, inc.Remove.Mounts, trace, "mounts")
+		base.Ports = removeEntries(base.Ports, 0, inc.Remove.Ports, trace, "ports")
+		base.Environment = removeEntries(base.Environment, 0, inc.Remove.Environment, trace, "environment")
+		base.Copies = removeEntries(base.Copies, 1, inc.Remove.Copies, trace, "copy")
+		base.Artifacts = removeEntries(base.Artifacts, 0, inc.Remove.Artifacts, trace, "artifacts")
+
+		dns := [][]string{}
+		for _, server := range base.DNS {
+			dns = append(dns, []string{ server })
+		}
+		base.DNS = []string{}
+		for _, server := range removeEntries(dns, 0, inc.Remove.DNS, trace, "dns") {
+			base.DNS = append(base.DNS, server[0])
+		}
+	}
+
+	if replace["mounts"] {
+		base.Mounts = append([][]string{}, inc.Mounts...)
+		trace.Reset(len(inc.Mounts), "mounts")
+	} else if meta.IsDefined(append(key, "mounts")...) {
 		base.Mounts = append(base.Mounts, inc.Mounts...)
+		trace.Add(len(inc.Mounts), "mounts")
 	}
 
-	if meta.IsDefined(append(key, "ports")...) {
+	if replace["ports"] {
+		base.Ports = append([][]string{}, inc.Ports...)
+		trace.Reset(len(inc.Ports), "ports")
+	} else if meta.IsDefined(append(key, "ports")...) {
 		base.Ports = append(base.Ports, inc.Ports...)
+		trace.Add(len(inc.Ports), "ports")
 	}
 
-	if meta.IsDefined(append(key, "dns")...) {
+	if replace["dns"] {
+		base.DNS = append([]string{}, inc.DNS...)
+		trace.Reset(len(inc.DNS), "dns")
+	} else if meta.IsDefined(append(key, "dns")...) {
 		base.DNS = append(base.DNS, inc.DNS...)
+		trace.Add(len(inc.DNS), "dns")
+	}
+
+	if replace["copy"] {
+		base.Copies = append([][]string{}, inc.Copies...)
+		trace.Reset(len(inc.Copies), "copy")
+	} else if meta.IsDefined(append(key, "copy")...) {
+		base.Copies = append(base.Copies, inc.Copies...)
+		trace.Add(len(inc.Copies), "copy")
+	}
+
+	if replace["artifacts"] {
+		base.Artifacts = append([][]string{}, inc.Artifacts...)
+		trace.Reset(len(inc.Artifacts), "artifacts")
+	} else if meta.IsDefined(append(key, "artifacts")...) {
+		base.Artifacts = append(base.Artifacts, inc.Artifacts...)
+		trace.Add(len(inc.Artifacts), "artifacts")
 	}
 
 	if meta.IsDefined(append(key, "attach")...) {
 		base.Attach = inc.Attach
+		trace.Set("attach")
 	}
 
 	if meta.IsDefined(append(key, "purge")...) {
 		base.Purge = inc.Purge
+		trace.Set("purge")
+	}
+
+	if replace["environment"] {
+		base.Environment = [][]string{}
+		trace.Reset(0, "environment")
+	}
+	if replace["environment"] || meta.IsDefined(append(key, "environment")...) {
+		//A variable that's already set gets the new value in place
+		environment := append(base.Environment[:0:0], base.Environment...)
+		for _, variable := range inc.Environment {
+			found := false
+			for i := range environment {
+				if environment[i][0] == variable[0] {
+					environment[i] = variable
+					trace.SetElement(i, "environment")
+					found = true
+				}
+			}
+			if !found {
+				environment = append(environment, variable)
+				trace.Add(1, "environment")
+			}
+		}
+		base.Environment = environment
+	}
+}
+
+//Returns a list without the entries whose value at the given index is one of the keys.
+func removeEntries(list [][]string, index int, keys []string, trace *Trace, key string) [][]string {
+	remove := map[string]bool{}
+	for _, k := range keys {
+		remove[k] = true
 	}
 
-	if meta.IsDefined(append(key, "environment")...) {
-		base.Environment = append(base.Environment, inc.Environment...)
+	kept := [][]string{}
+	removed := 0
+	for i, entry := range list {
+		if len(entry) > index && remove[entry[index]] {
+			trace.RemoveElement(i - removed, key)
+			removed++
+		} else {
+			kept = append(kept, entry)
+		}
 	}
+	return kept
 }
@@ -0,0 +1,224 @@
+package conf
+
+// Finds where keys are in TOML text, so problems can be reported by line.
+// The toml library doesn't keep track of this, so we take our own (forgiving) pass over the text.
+
+import (
+	"strconv"
+	"strings"
+)
+
+//Line numbers of keys in a TOML document, by dotted path.
+//Entries of array values are under the key's path plus "#" and the entry's index.
+type keyLines map[string]int
+
+//Returns the line of a key, or of one of its entries if element isn't -1; zero if it can't be found.
+func (l keyLines) Line(key string, element int) int {
+	if element >= 0 {
+		if line, ok := l[key+"#"+strconv.Itoa(element)]; ok {
+			return line
+		}
+	}
+	return l[key]
+}
+
+func locateTOML(data string) keyLines {
+	s := &tomlScanner{ data: data, line: 1, lines: keyLines{} }
+	table := []string{}
+	for {
+		s.skipSpace(true)
+		if s.done() {
+			break
+		}
+		switch s.peek() {
+			case '[':
+				// table header, maybe an array of tables
+				end := strings.Index(s.data[s.pos:], "\n")
+				if end < 0 {
+					end = len(s.data) - s.pos
+				}
+				header := strings.Trim(strings.TrimSpace(stripComment(s.data[s.pos:s.pos+end])), "[]")
+				table = splitKey(header)
+				s.lines[strings.Join(table, ".")] = s.line
+				s.pos += end
+			default:
+				// key = value
+				eq := s.findOutsideQuotes('=')
+				if eq < 0 {
+					return s.lines
+				}
+				path := strings.Join(append(append([]string{}, table...), splitKey(s.data[s.pos:eq])...), ".")
+				s.lines[path] = s.line
+				s.pos = eq + 1
+				s.skipSpace(false)
+				s.value(path)
+		}
+	}
+	return s.lines
+}
+
+type tomlScanner struct {
+	data  string
+	pos   int
+	line  int
+	lines keyLines
+}
+
+func (s *tomlScanner) done() bool { return s.pos >= len(s.data) }
+func (s *tomlScanner) peek() byte { return s.data[s.pos] }
+
+func (s *tomlScanner) advance() {
+	if s.data[s.pos] == '\n' {
+		s.line++
+	}
+	s.pos++
+}
+
+//Skips whitespace and comments; newlines too, if asked.
+func (s *tomlScanner) skipSpace(newlines bool) {
+	for !s.done() {
+		switch c := s.peek(); {
+			case c == ' ' || c == '\t' || c == '\r':
+				s.advance()
+			case c == '\n' && newlines:
+				s.advance()
+			case c == '#':
+				for !s.done() && s.peek() != '\n' {
+					s.advance()
+				}
+			default:
+				return
+		}
+	}
+}
+
+//Finds the next occurence of a character on this line that isn't in a quoted key.
+func (s *tomlScanner) findOutsideQuotes(want byte) int {
+	quote := byte(0)
+	for i := s.pos; i < len(s.data) && s.data[i] != '\n'; i++ {
+		c := s.data[i]
+		switch {
+			case quote != 0 && c == '\\' && quote == '"':
+				i++
+			case quote != 0 && c == quote:
+				quote = 0
+			case quote == 0 && (c == '"' || c == '\''):
+				quote = c
+			case quote == 0 && c == want:
+				return i
+		}
+	}
+	return -1
+}
+
+//Skips over a value, noting where each entry of an array starts.
+func (s *tomlScanner) value(path string) {
+	if s.done() {
+		return
+	}
+	switch s.peek() {
+		case '"', '\'':
+			s.str()
+		case '[', '{':
+			open := s.peek()
+			close := byte(']')
+			if open == '{' {
+				close = '}'
+			}
+			s.advance()
+			for n := 0; ; n++ {
+				s.skipSpace(true)
+				if s.done() {
+					return
+				}
+				if s.peek() == close {
+					s.advance()
+					return
+				}
+				if open == '[' && path != "" {
+					s.lines[path+"#"+strconv.Itoa(n)] = s.line
+				}
+				if open == '{' {
+					// inline table: skip the key
+					for !s.done() && s.peek() != '=' {
+						s.advance()
+					}
+					if !s.done() {
+						s.advance()
+					}
+					s.skipSpace(true)
+				}
+				s.value("")
+				s.skipSpace(true)
+				if !s.done() && s.peek() == ',' {
+					s.advance()
+				}
+			}
+		default:
+			for !s.done() && strings.IndexByte(" \t\r\n,]}#", s.peek()) < 0 {
+				s.advance()
+			}
+	}
+}
+
+//Skips over a string of any of TOML's four kinds.
+func (s *tomlScanner) str() {
+	quote := s.data[s.pos:s.pos+1]
+	if strings.HasPrefix(s.data[s.pos:], quote+quote+quote) {
+		quote = quote + quote + quote
+	}
+	for i := 0; i < len(quote); i++ {
+		s.advance()
+	}
+	for !s.done() {
+		if quote[0] == '"' && s.peek() == '\\' {
+			s.advance()
+			if !s.done() {
+				s.advance()
+			}
+			continue
+		}
+		if strings.HasPrefix(s.data[s.pos:], quote) {
+			for i := 0; i < len(quote); i++ {
+				s.advance()
+			}
+			return
+		}
+		s.advance()
+	}
+}
+
+//Splits a dotted key into its parts, unquoting any quoted ones.
+func splitKey(key string) []string {
+	parts := []string{}
+	current := ""
+	quote := byte(0)
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		switch {
+			case quote != 0 && c == '\\' && quote == '"' && i+1 < len(key):
+				i++
+				current += string(key[i])
+			case quote != 0 && c == quote:
+				quote = 0
+			case quote != 0:
+				current += string(c)
+			case c == '"' || c == '\'':
+				quote = c
+			case c == '.':
+				parts = append(parts, strings.TrimSpace(current))
+				current = ""
+			case c != ' ' && c != '\t':
+				current += string(c)
+		}
+	}
+	return append(parts, strings.TrimSpace(current))
+}
+
+//Drops a trailing comment from a table header line.
+func stripComment(line string) string {
+	if i := strings.Index(line, "#"); i >= 0 && !strings.ContainsAny(line[:i], "\"'") {
+		return line[:i]
+	}
+	return line
+}
@@ -1,13 +1,16 @@
 package conf
 
 import (
+	"bytes"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"github.com/coocood/assrt"
 )
 
-func parser() *TomlConfigParser {
-	return &TomlConfigParser{}
+func parser() *FileConfigParser {
+	return &FileConfigParser{}
 }
 
 func TestTomlParser(t *testing.T) {
@@ -124,3 +127,803 @@ func TestTomlParser(t *testing.T) {
 	assert.Equal(1, len(conf.Targets))
 	assert.Equal(expect.Settings, conf.Targets["bash"])
 }
+
+func TestTomlSigning(t *testing.T) {
+	assert := assrt.NewAssert(t)
+	nwd, _ := filepath.Abs("..")
+
+	f1 := `
+	[signing]
+		keyring = ".../trusted.gpg"
+	`
+	f2 := `
+	[signing]
+		key = "builds@example.com"
+	`
+	conf := parser().
+		AddConfig(f1, "..").
+		AddConfig(f2, "." ).
+		GetConfig()
+	assert.Equal(
+		Signing{
+			Key:     "builds@example.com",
+			Keyring: filepath.Join(nwd, "trusted.gpg"),
+		},
+		conf.Signing,
+	)
+}
+
+func TestTomlRetention(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	f1 := `
+	[image]
+		name = "example.com/ubuntu"
+		upstream = "index.docker.io/ubuntu"
+
+	[image.prune]
+		keep = 10
+		before = "3 months ago"
+	`
+	conf := parser().
+		AddConfig(f1, ".").
+		GetConfig()
+	assert.Equal(
+		Retention{
+			Keep:   10,
+			Before: "3 months ago",
+		},
+		conf.Image.Prune,
+	)
+}
+
+func TestTomlProvenance(t *testing.T) {
+	assert := assrt.NewAssert(t)
+	top, _ := filepath.Abs("../hroot.toml")
+	here, _ := filepath.Abs("hroot.toml")
+
+	f1 := `
+	[settings]
+		dns = [ "8.8.8.8" ]
+		folder = "/"
+	`
+	f2 := `
+	[settings]
+		dns = [ "8.8.4.4", "1.1.1.1" ]
+
+	[image]
+		name = "example.com/ubuntu"
+
+	[target.run]
+		folder = "/hroot"
+	`
+	p := parser()
+	p.AddConfig(f1, "..").AddConfig(f2, ".")
+	prov := p.GetProvenance()
+
+	assert.Equal([]string{ top, here, here }, prov["settings.dns"])
+	assert.Equal([]string{ top }, prov["settings.folder"])
+	assert.Equal([]string{ here }, prov["image.name"])
+	assert.Equal([]string{ top, here, here }, prov["target.run.dns"])
+	assert.Equal([]string{ here }, prov["target.run.folder"])
+	assert.Equal(([]string)(nil), prov["settings.command"])
+}
+
+func TestTomlValidation(t *testing.T) {
+	assert := assrt.NewAssert(t)
+	file, _ := filepath.Abs("hroot.toml")
+
+	f1 := `
+	[settings]
+		mounts = [
+			[ "./", "/hroot", "rw" ],
+			[ "./", "/oops" ],
+		]
+		dsn = [ "8.8.8.8" ]
+
+	[target.run]
+		environment = [ [ "HOME" ] ]
+		ports = [ [ "80", "8080" ], [ "22" ] ]
+	`
+	problems := parser().Validate(f1, ConfigFileName)
+	messages := []string{}
+	for _, problem := range problems {
+		messages = append(messages, problem.String())
+	}
+	assert.Equal(4, len(problems), messages)
+
+	found := map[string]ConfigProblem{}
+	for _, problem := range problems {
+		found[problem.Key] = problem
+		assert.Equal(file, problem.File)
+	}
+	assert.Equal(5, found["settings.mounts"].Line)
+	assert.Equal(1, found["settings.mounts"].Element)
+	assert.True(found["settings.mounts"].Fatal)
+	assert.Equal(7, found["settings.dsn"].Line)
+	assert.False(found["settings.dsn"].Fatal)
+	assert.Equal(10, found["target.run.environment"].Line)
+	assert.Equal(11, found["target.run.ports"].Line)
+	assert.Equal(1, found["target.run.ports"].Element)
+
+	// a file that won't decode is one problem
+	problems = parser().Validate("mounts = [ [ \"a\"", ConfigFileName)
+	assert.Equal(1, len(problems))
+	assert.True(problems[0].Fatal)
+
+	// and clean files have none
+	assert.Equal(0, len(parser().Validate("[settings]\n\tdns = [ \"8.8.8.8\" ]\n", ConfigFileName)))
+
+	// loading keeps going past the warnings, and hands them back
+	p := parser()
+	p.AddConfig("[settings]\n\tdsn = [ \"8.8.8.8\" ]\n\tcommand = [ \"ls\" ]\n", ".")
+	warned := []string{}
+	for _, problem := range p.Warnings() {
+		warned = append(warned, problem.Key)
+	}
+	assert.Equal([]string{ "settings.command", "settings.dsn" }, warned)
+	assert.Equal([]string{ "ls" }, p.GetConfig().Settings.Command)
+}
+
+func TestTomlTargetInheritance(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	f1 := `
+	[settings]
+		dns = [ "8.8.8.8" ]
+
+	[target.test]
+		command = [ "make", "test" ]
+		folder = "/src"
+
+	[target.run]
+		command = [ "/bin/bash" ]
+	`
+	f2 := `
+	[settings]
+		folder = "/home"
+		dns = [ "8.8.4.4" ]
+
+	[target.run]
+		attach = true
+	`
+	conf := parser().
+		AddConfig(f1, "..").
+		AddConfig(f2, "." ).
+		GetConfig()
+	assert.Equal(2, len(conf.Targets))
+
+	// the parent's target survives the child defining targets, and picks up the child's settings...
+	assert.Equal([]string{ "make", "test" }, conf.Targets["test"].Command)
+	assert.Equal([]string{ "8.8.8.8", "8.8.4.4" }, conf.Targets["test"].DNS)
+
+	// ...but its own keys beat settings, even from deeper files.
+	assert.Equal("/src", conf.Targets["test"].Folder)
+
+	// a target in both files gets keys from both.
+	assert.Equal([]string{ "/bin/bash" }, conf.Targets["run"].Command)
+	assert.Equal(true, conf.Targets["run"].Attach)
+	assert.Equal("/home", conf.Targets["run"].Folder)
+}
+
+func TestTomlTargetExtends(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	f1 := `
+	[target.run]
+		command = [ "/bin/bash" ]
+		dns = [ "8.8.8.8" ]
+		folder = "/src"
+
+	[target.debug]
+		extends = "run"
+		attach = true
+		dns = [ "8.8.4.4" ]
+	`
+	f2 := `
+	[target.trace]
+		extends = "debug"
+		command = [ "strace", "/bin/bash" ]
+	`
+	conf := parser().
+		AddConfig(f1, "..").
+		AddConfig(f2, "." ).
+		GetConfig()
+
+	debug := conf.Targets["debug"]
+	assert.Equal([]string{ "/bin/bash" }, debug.Command)
+	assert.Equal([]string{ "8.8.8.8", "8.8.4.4" }, debug.DNS)
+	assert.Equal(true, debug.Attach)
+	assert.Equal("/src", debug.Folder)
+
+	trace := conf.Targets["trace"]
+	assert.Equal([]string{ "strace", "/bin/bash" }, trace.Command)
+	assert.Equal([]string{ "8.8.8.8", "8.8.4.4" }, trace.DNS)
+	assert.Equal(true, trace.Attach)
+
+	// the base target isn't changed by what extends it
+	assert.Equal([]string{ "8.8.8.8" }, conf.Targets["run"].DNS)
+
+	// loops are refused
+	f3 := `
+	[target.a]
+		extends = "b"
+	[target.b]
+		extends = "a"
+	`
+	defer func() {
+		err := recover()
+		if err == nil { t.Fail(); }
+	}()
+	parser().AddConfig(f3, ".").GetConfig()
+}
+
+func TestTomlListOverrides(t *testing.T) {
+	assert := assrt.NewAssert(t)
+	cwd, _ := filepath.Abs(".")
+	nwd, _ := filepath.Abs("..")
+
+	f1 := `
+	[settings]
+		mounts = [
+			[ ".../", "/boxen", "rw" ],
+			[ ".../cache", "/cache", "rw" ],
+		]
+		ports = [ [ "80", "80" ], [ "443", "443" ] ]
+		dns = [ "8.8.8.8", "8.8.4.4" ]
+		environment = [ [ "HOME", "/root" ], [ "LANG", "C" ] ]
+	`
+
+	// environment variables override by name, rather than doubling up
+	f2 := `
+	[settings]
+		environment = [ [ "LANG", "en_US.UTF-8" ], [ "TERM", "xterm" ] ]
+	`
+	conf := parser().
+		AddConfig(f1, "..").
+		AddConfig(f2, "." ).
+		GetConfig()
+	assert.Equal(
+		[][]string{
+			[]string{ "HOME", "/root" },
+			[]string{ "LANG", "en_US.UTF-8" },
+			[]string{ "TERM", "xterm" },
+		},
+		conf.Settings.Environment,
+	)
+
+	// lists can be replaced outright
+	f3 := `
+	[settings]
+		replace = [ "dns", "ports" ]
+		dns = [ "10.0.0.1" ]
+	`
+	conf = parser().
+		AddConfig(f1, "..").
+		AddConfig(f3, "." ).
+		GetConfig()
+	assert.Equal([]string{ "10.0.0.1" }, conf.Settings.DNS)
+	assert.Equal([][]string{}, conf.Settings.Ports)
+
+	// or have entries removed by key, and added back differently
+	f4 := `
+	[settings]
+		mounts = [ [ ".../", "/boxen", "ro" ] ]
+
+	[settings.remove]
+		mounts = [ "/boxen" ]
+		ports = [ "443" ]
+		dns = [ "8.8.4.4" ]
+		environment = [ "HOME" ]
+	`
+	p := parser()
+	conf = p.
+		AddConfig(f1, "..").
+		AddConfig(f4, "." ).
+		GetConfig()
+	assert.Equal(
+		[][]string{
+			[]string{ filepath.Join(nwd, "cache"), "/cache", "rw" },
+			[]string{ cwd, "/boxen", "ro" },
+		},
+		conf.Settings.Mounts,
+	)
+	assert.Equal([][]string{ []string{ "80", "80" } }, conf.Settings.Ports)
+	assert.Equal([]string{ "8.8.8.8" }, conf.Settings.DNS)
+	assert.Equal([][]string{ []string{ "LANG", "C" } }, conf.Settings.Environment)
+
+	// provenance follows along
+	top, _ := filepath.Abs("../hroot.toml")
+	here, _ := filepath.Abs("hroot.toml")
+	assert.Equal([]string{ top, here }, p.GetProvenance()["settings.mounts"])
+	assert.Equal([]string{ top }, p.GetProvenance()["settings.dns"])
+
+	// targets can drop what they inherit from settings
+	f5 := `
+	[target.run]
+		replace = [ "mounts" ]
+	`
+	conf = parser().
+		AddConfig(f1, "..").
+		AddConfig(f5, "." ).
+		GetConfig()
+	assert.Equal(0, len(conf.Targets["run"].Mounts))
+	assert.Equal(2, len(conf.Settings.Mounts))
+}
+
+func TestTomlInterpolation(t *testing.T) {
+	assert := assrt.NewAssert(t)
+	cwd, _ := filepath.Abs(".")
+	nwd, _ := filepath.Abs("..")
+	os.Setenv("HROOT_TEST_USER", "alice")
+
+	f1 := `
+	[settings]
+		mounts = [ [ "${CONFIG_DIR}/cache", "/cache", "rw" ] ]
+		environment = [
+			[ "BUILDER", "${HROOT_TEST_USER}" ],
+			[ "SHELL", "${HROOT_TEST_UNSET:-/bin/sh}" ],
+		]
+	`
+	f2 := `
+	[image]
+		name = "example.com/${HROOT_TEST_USER}/app"
+
+	[target.run]
+		command = [ "echo", "${TARGET} of ${IMAGE_NAME} in ${PROJECT_ROOT}", "$${HOME}" ]
+		folder = "/work/${TARGET}"
+	`
+	conf := parser().
+		AddConfig(f1, "..").
+		AddConfig(f2, "." ).
+		GetConfig()
+	assert.Equal("example.com/alice/app", conf.Image.Name)
+	assert.Equal(
+		[][]string{
+			[]string{ filepath.Join(nwd, "cache"), "/cache", "rw" },
+		},
+		conf.Settings.Mounts,
+	)
+	assert.Equal(
+		[][]string{
+			[]string{ "BUILDER", "alice" },
+			[]string{ "SHELL", "/bin/sh" },
+		},
+		conf.Settings.Environment,
+	)
+	assert.Equal(
+		[]string{ "echo", "run of example.com/alice/app in " + nwd, "${HOME}" },
+		conf.Targets["run"].Command,
+	)
+	assert.Equal("/work/run", conf.Targets["run"].Folder)
+
+	// each target sees its own name, even when it extends another
+	f3 := `
+	[image]
+		name = "app"
+
+	[target.build]
+		extends = "run"
+	`
+	conf = parser().
+		AddConfig(f1, "..").
+		AddConfig(f2, cwd).
+		AddConfig(f3, "." ).
+		GetConfig()
+	assert.Equal("/work/run", conf.Targets["run"].Folder)
+	assert.Equal("/work/build", conf.Targets["build"].Folder)
+
+	// what a variable expands to is used as it is, and escapes are only undone once
+	os.Setenv("HROOT_TEST_PRICE", "$${TARGET} $5")
+	conf = parser().
+		AddConfig("[target.run]\n\tcommand = [ \"echo\", \"${HROOT_TEST_PRICE}\", \"$$$$\", \"${HROOT_TEST_UNSET:-$$}\" ]\n", ".").
+		GetConfig()
+	assert.Equal([]string{ "echo", "$${TARGET} $5", "$$", "$$" }, conf.Targets["run"].Command)
+
+	// undefined variables are fatal problems, found on the line they're used
+	f4 := `
+	[settings]
+		command = [ "echo" ]
+		folder = "${HROOT_TEST_UNSET}"
+
+	[image]
+		name = "${TARGET}"
+	`
+	problems := parser().Validate(f4, ConfigFileName)
+	found := map[string]ConfigProblem{}
+	for _, problem := range problems {
+		found[problem.Key] = problem
+	}
+	assert.True(found["settings.folder"].Fatal)
+	assert.Equal(4, found["settings.folder"].Line)
+	assert.True(found["image.name"].Fatal)
+	assert.Equal(7, found["image.name"].Line)
+
+	// and refuse to load
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fail()
+			}
+		}()
+		parser().AddConfig("[settings]\n\tfolder = \"${HROOT_TEST_UNSET}\"\n", ".")
+	}()
+}
+
+func TestTomlGraph(t *testing.T) {
+	assert := assrt.NewAssert(t)
+	nwd, _ := filepath.Abs("..")
+
+	f1 := `
+	[graph]
+		path = ".../shared-graph"
+
+	[graph.remotes]
+		team = "git@example.com:team/graph.git"
+		backup = ".../backup"
+	`
+	f2 := `
+	[graph.remotes]
+		team = "https://example.com/team/graph.git"
+	`
+	p := parser()
+	conf := p.
+		AddConfig(f1, "..").
+		AddConfig(f2, "." ).
+		GetConfig()
+	assert.Equal(filepath.Join(nwd, "shared-graph"), conf.Graph.Path)
+	assert.Equal(
+		map[string]string{
+			"team":   "https://example.com/team/graph.git",
+			"backup": filepath.Join(nwd, "backup"),
+		},
+		conf.Graph.Remotes,
+	)
+
+	here, _ := filepath.Abs("hroot.toml")
+	assert.Equal([]string{ here }, p.GetProvenance()["graph.remotes.team"])
+
+	// graphs don't belong to any target
+	problems := parser().Validate("[graph]\n\tpath = \"${TARGET}\"\n", ConfigFileName)
+	assert.Equal(1, len(problems))
+	assert.True(problems[0].Fatal)
+}
+
+func TestTomlCopy(t *testing.T) {
+	assert := assrt.NewAssert(t)
+	nwd, _ := filepath.Abs("..")
+	cwd, _ := filepath.Abs(".")
+
+	f1 := `
+	[image]
+		name = "example.com/app"
+		copy = [ [ ".../etc/app.conf", "/etc/app.conf", "0:0", "0644" ] ]
+
+	[settings]
+		copy = [ [ ".../src", "/src" ] ]
+
+	[target.build]
+		copy = [ [ ".../tools", "/opt/tools", "1000" ] ]
+	`
+	f2 := `
+	[image]
+		name = "example.com/app"
+
+	[target.build]
+		copy = [ [ ".../scripts", "/scripts", "1000:1000", "755" ] ]
+
+	[target.build.remove]
+		copy = [ "/src" ]
+	`
+	conf := parser().
+		AddConfig(f1, "..").
+		AddConfig(f2, "." ).
+		GetConfig()
+
+	// the image is replaced wholesale, copies and all
+	assert.Equal(0, len(conf.Image.Copies))
+	assert.Equal([][]string{ { filepath.Join(nwd, "src"), "/src" } }, conf.Settings.Copies)
+	assert.Equal(
+		[][]string{
+			{ filepath.Join(nwd, "tools"), "/opt/tools", "1000" },
+			{ filepath.Join(cwd, "scripts"), "/scripts", "1000:1000", "755" },
+		},
+		conf.Targets["build"].Copies,
+	)
+
+	conf = parser().AddConfig(f1, "..").GetConfig()
+	assert.Equal([][]string{ { filepath.Join(nwd, "etc/app.conf"), "/etc/app.conf", "0:0", "0644" } }, conf.Image.Copies)
+
+	for _, bad := range []string{
+		`[ "/a" ]`,
+		`[ "/a", "relative" ]`,
+		`[ "/a", "/b", "root" ]`,
+		`[ "/a", "/b", "0", "rwx" ]`,
+	} {
+		problems := parser().Validate("[settings]\n\tcopy = [ " + bad + " ]\n", ConfigFileName)
+		assert.Equal(1, len(problems))
+		assert.True(problems[0].Fatal)
+	}
+}
+
+func TestTomlFrom(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	f := `
+	[image]
+		name = "example.com/app"
+		upstream = "example.com/slim"
+		from = [
+			[ "example.com/compiler", "/out/app", "/usr/local/bin/app" ],
+			[ "example.com/compiler", "/usr/lib/libc.so", "/usr/lib/libc.so", "3f2a9c1" ],
+		]
+	`
+	conf := parser().AddConfig(f, ".").GetConfig()
+	assert.Equal(
+		[][]string{
+			{ "example.com/compiler", "/out/app", "/usr/local/bin/app" },
+			{ "example.com/compiler", "/usr/lib/libc.so", "/usr/lib/libc.so", "3f2a9c1" },
+		},
+		conf.Image.From,
+	)
+
+	for _, bad := range []string{
+		`[ "example.com/compiler", "/out/app" ]`,
+		`[ "example.com/compiler", "out/app", "/app" ]`,
+		`[ "", "/out/app", "/app" ]`,
+	} {
+		problems := parser().Validate("[image]\n\tfrom = [ " + bad + " ]\n", ConfigFileName)
+		assert.Equal(1, len(problems))
+		assert.True(problems[0].Fatal)
+	}
+}
+
+func TestTomlArtifacts(t *testing.T) {
+	assert := assrt.NewAssert(t)
+	nwd, _ := filepath.Abs("..")
+	cwd, _ := filepath.Abs(".")
+
+	f1 := `
+	[target.build]
+		artifacts = [ [ "/src/app", ".../bin/app" ], [ "/src/report.html", ".../report.html" ] ]
+	`
+	f2 := `
+	[target.build]
+		artifacts = [ [ "/src/docs", ".../docs" ] ]
+
+	[target.build.remove]
+		artifacts = [ "/src/report.html" ]
+	`
+	conf := parser().
+		AddConfig(f1, "..").
+		AddConfig(f2, "." ).
+		GetConfig()
+	assert.Equal(
+		[][]string{
+			{ "/src/app", filepath.Join(nwd, "bin/app") },
+			{ "/src/docs", filepath.Join(cwd, "docs") },
+		},
+		conf.Targets["build"].Artifacts,
+	)
+
+	for _, bad := range []string{
+		`[ "/a" ]`,
+		`[ "relative", "/b" ]`,
+		`[ "/a", "" ]`,
+	} {
+		problems := parser().Validate("[target.build]\n\tartifacts = [ " + bad + " ]\n", ConfigFileName)
+		assert.Equal(1, len(problems))
+		assert.True(problems[0].Fatal)
+	}
+}
+
+func TestTomlSteps(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	f1 := `
+	[settings]
+		folder = "/src"
+		environment = [ [ "LANG", "C" ], [ "CC", "gcc" ] ]
+
+	[target.build]
+		command = [ "make" ]
+
+	[[target.build.step]]
+		command = [ "./configure" ]
+		cache = true
+
+	[[target.build.step]]
+		command = [ "make", "install" ]
+		folder = "/src/build"
+		environment = [ [ "CC", "clang" ] ]
+
+	[target.quick]
+		extends = "build"
+		command = [ "make", "quick" ]
+	`
+	// a file's command and steps can't both be for one target
+	problems := parser().Validate(f1, ConfigFileName)
+	assert.Equal(1, len(problems))
+	assert.True(problems[0].Fatal)
+
+	f1 = strings.Replace(f1, "command = [ \"make\" ]", "", 1)
+	problems = parser().Validate(f1, ConfigFileName)
+	assert.Equal(0, len(problems))
+
+	conf := parser().AddConfig(f1, ".").GetConfig()
+	build := conf.Targets["build"]
+	assert.Equal(2, len(build.Steps))
+	assert.Equal([]string{ "./configure" }, build.Steps[0].Command)
+	assert.True(build.Steps[0].Cache)
+	assert.False(build.Steps[1].Cache)
+
+	// a command from a more specific place takes over from steps
+	assert.Equal(0, len(conf.Targets["quick"].Steps))
+	assert.Equal([]string{ "make", "quick" }, conf.Targets["quick"].Command)
+
+	// each step runs with the target's settings, changed by its own
+	first := build.ForStep(build.Steps[0])
+	assert.Equal([]string{ "./configure" }, first.Command)
+	assert.Equal("/src", first.Folder)
+	second := build.ForStep(build.Steps[1])
+	assert.Equal("/src/build", second.Folder)
+	assert.Equal([][]string{ { "LANG", "C" }, { "CC", "clang" } }, second.Environment)
+	assert.Equal([][]string{ { "LANG", "C" }, { "CC", "gcc" } }, build.Environment)
+
+	// steps are described as an array of tables
+	var out bytes.Buffer
+	DescribeTOML(&out, conf, Provenance{}, "build")
+	assert.Equal(2, strings.Count(out.String(), "[[target.build.step]]"))
+
+	// settings can't have steps any more than they can have a command, which only matters to commands that run them
+	problems = parser().Validate("[[settings.step]]\n\tcommand = [ \"true\" ]\n", ConfigFileName)
+	assert.Equal(1, len(problems))
+	assert.Equal("settings.step", problems[0].Key)
+	assert.False(problems[0].Fatal)
+}
+
+func TestJSONConfig(t *testing.T) {
+	assert := assrt.NewAssert(t)
+	cwd, _ := filepath.Abs(".")
+
+	// JSON files merge just like TOML ones, and the two can be mixed
+	f1 := `
+	[settings]
+		dns = [ "8.8.8.8" ]
+		environment = [ [ "LANG", "C" ] ]
+
+	[target.run]
+		command = [ "bash" ]
+	`
+	f2 := `{
+		"image": { "name": "example.com/app" },
+		"settings": {
+			"dns": [ "8.8.4.4" ],
+			"environment": [ [ "LANG", "en_US.UTF-8" ] ],
+			"mounts": [ [ ".../", "/src", "ro" ] ]
+		},
+		"target": {
+			"run": { "attach": true }
+		}
+	}`
+	p := parser()
+	p.AddConfig(f1, "..")
+	conf := p.AddConfigFile(f2, "hroot.json").GetConfig()
+	assert.Equal("example.com/app", conf.Image.Name)
+	assert.Equal([]string{ "8.8.8.8", "8.8.4.4" }, conf.Settings.DNS)
+	assert.Equal([][]string{ []string{ "LANG", "en_US.UTF-8" } }, conf.Settings.Environment)
+	assert.Equal([][]string{ []string{ cwd, "/src", "ro" } }, conf.Settings.Mounts)
+	assert.Equal([]string{ "bash" }, conf.Targets["run"].Command)
+	assert.True(conf.Targets["run"].Attach)
+
+	here, _ := filepath.Abs("hroot.json")
+	assert.Equal([]string{ here }, p.GetProvenance()["image.name"])
+
+	// a folder is always a folder, even one named like a config file
+	p = parser()
+	p.AddConfig(f1, "hroot.json")
+	inside, _ := filepath.Abs(filepath.Join("hroot.json", ConfigFileName))
+	assert.Equal([]string{ inside }, p.GetProvenance()["settings.dns"])
+
+	// problems are found by line, same as TOML
+	f3 := `{
+	"settings": {
+		"mounts": [
+			[ "./", "/hroot", "rw" ],
+			[ "./", "/oops" ]
+		],
+		"dsn": [ "8.8.8.8" ]
+	}
+}`
+	problems := parser().Validate(f3, "hroot.json")
+	found := map[string]ConfigProblem{}
+	for _, problem := range problems {
+		found[problem.Key] = problem
+		assert.Equal(here, problem.File)
+	}
+	assert.Equal(2, len(problems))
+	assert.Equal(5, found["settings.mounts"].Line)
+	assert.True(found["settings.mounts"].Fatal)
+	assert.Equal(7, found["settings.dsn"].Line)
+	assert.False(found["settings.dsn"].Fatal)
+
+	// and a file that won't decode is one problem
+	problems = parser().Validate(`{ "settings": { "dns": "8.8.8.8" } }`, "hroot.json")
+	assert.Equal(1, len(problems))
+	assert.True(problems[0].Fatal)
+}
+
+func TestTomlProfiles(t *testing.T) {
+	assert := assrt.NewAssert(t)
+	nwd, _ := filepath.Abs("..")
+	cwd, _ := filepath.Abs(".")
+
+	f1 := `
+	[settings]
+		dns = [ "8.8.8.8" ]
+		mounts = [ [ ".../", "/src", "rw" ] ]
+
+	[target.run]
+		command = [ "bash" ]
+
+	[profile.ci.settings]
+		replace = [ "dns" ]
+		dns = [ "10.0.0.1" ]
+
+	[profile.ci.settings.remove]
+		mounts = [ "/src" ]
+	`
+	f2 := `
+	[settings]
+		dns = [ "8.8.4.4" ]
+
+	[target.run]
+		attach = true
+
+	[profile.ci.target.run]
+		attach = false
+		mounts = [ [ ".../", "/src", "ro" ] ]
+	`
+
+	// without a profile, profiles change nothing
+	conf := parser().
+		AddConfig(f1, "..").
+		AddConfig(f2, "." ).
+		GetConfig()
+	assert.Equal([]string{ "8.8.8.8", "8.8.4.4" }, conf.Settings.DNS)
+	assert.True(conf.Targets["run"].Attach)
+
+	// with one, it goes on top of every file, even ones deeper than it
+	p := parser()
+	p.UseProfile("ci")
+	conf = p.
+		AddConfig(f1, "..").
+		AddConfig(f2, "." ).
+		GetConfig()
+	assert.Equal([]string{ "10.0.0.1" }, conf.Settings.DNS)
+	assert.Equal(0, len(conf.Settings.Mounts))
+	assert.Equal([]string{ "10.0.0.1" }, conf.Targets["run"].DNS)
+	assert.Equal([]string{ "bash" }, conf.Targets["run"].Command)
+	assert.False(conf.Targets["run"].Attach)
+	assert.Equal([][]string{ []string{ cwd, "/src", "ro" } }, conf.Targets["run"].Mounts)
+
+	top := filepath.Join(nwd, "hroot.toml")
+	assert.Equal([]string{ top }, p.GetProvenance()["settings.dns"])
+
+	// merging again changes nothing
+	conf = p.GetConfig()
+	assert.Equal([]string{ "10.0.0.1" }, conf.Settings.DNS)
+	assert.Equal(1, len(conf.Targets["run"].Mounts))
+	assert.Equal([]string{ top }, p.GetProvenance()["settings.dns"])
+
+	// asking for a profile nobody configured is an error
+	p = parser()
+	p.UseProfile("prod")
+	p.AddConfig(f1, "..")
+	defer func() {
+		if recover() == nil {
+			t.Fail()
+		}
+	}()
+	p.GetConfig()
+}
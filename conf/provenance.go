@@ -0,0 +1,92 @@
+package conf
+
+import (
+	"strings"
+)
+
+//Where each configuration value came from.
+//Keys are dotted paths, like "settings.dns" or "target.run.command".
+//A plain value has one source; a list that was appended to has one source per element.
+type Provenance map[string][]string
+
+//Drops every key under a prefix.
+func (p Provenance) Clear(prefix string) {
+	for key := range p {
+		if key == prefix || strings.HasPrefix(key, prefix+".") {
+			delete(p, key)
+		}
+	}
+}
+
+//Copies every key under one prefix to the same place under another.
+func (p Provenance) Copy(from, to string) {
+	for key, sources := range p {
+		if strings.HasPrefix(key, from+".") {
+			p[to+strings.TrimPrefix(key, from)] = append([]string{}, sources...)
+		}
+	}
+}
+
+//Records values being loaded from one source, into one part of a Provenance.
+//A nil trace records nothing.
+type Trace struct {
+	Provenance Provenance
+	Source     string
+
+	//Dotted path the keys are under, if any
+	Prefix     string
+}
+
+//A trace of the same source into a deeper part of the configuration.
+func (t *Trace) Under(key ...string) *Trace {
+	if t == nil { return nil }
+	return &Trace{
+		Provenance: t.Provenance,
+		Source:     t.Source,
+		Prefix:     t.key(key),
+	}
+}
+
+//Records a value being set, replacing whatever set it before.
+func (t *Trace) Set(key ...string) {
+	if t == nil { return }
+	t.Provenance[t.key(key)] = []string{ t.Source }
+}
+
+//Records n elements being appended to a list.
+func (t *Trace) Add(n int, key ...string) {
+	if t == nil { return }
+	for i := 0; i < n; i++ {
+		t.Provenance[t.key(key)] = append(t.Provenance[t.key(key)], t.Source)
+	}
+}
+
+//Records a list being replaced by n elements.
+func (t *Trace) Reset(n int, key ...string) {
+	if t == nil { return }
+	delete(t.Provenance, t.key(key))
+	t.Add(n, key...)
+}
+
+//Records one element of a list being replaced.
+func (t *Trace) SetElement(i int, key ...string) {
+	if t == nil { return }
+	if sources := t.Provenance[t.key(key)]; i < len(sources) {
+		sources[i] = t.Source
+	}
+}
+
+//Records one element of a list being removed.
+func (t *Trace) RemoveElement(i int, key ...string) {
+	if t == nil { return }
+	if sources := t.Provenance[t.key(key)]; i < len(sources) {
+		t.Provenance[t.key(key)] = append(sources[:i:i], sources[i+1:]...)
+	}
+}
+
+func (t *Trace) key(key []string) string {
+	if t.Prefix == "" {
+		return strings.Join(key, ".")
+	}
+	return t.Prefix + "." + strings.Join(key, ".")
+}
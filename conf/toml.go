@@ -0,0 +1,46 @@
+package conf
+
+// The only file that imports toml.
+// Keeps our chosen file format isolated from the rest of the system.
+
+import (
+	"github.com/BurntSushi/toml"
+	. "polydawn.net/hroot/util"
+)
+
+//Reads hroot.toml files.
+type TomlFormat struct {}
+
+func (TomlFormat) FileName() string {
+	return ConfigFileName
+}
+
+func (TomlFormat) Decode(data string) (*Configuration, KeySet, error) {
+	var set Configuration
+	md, err := toml.Decode(data, &set)
+	return &set, tomlKeys{ &md }, err
+}
+
+func (TomlFormat) Locate(data string) Lines {
+	return locateTOML(data)
+}
+
+//The keys a TOML file set, as the toml library reports them.
+type tomlKeys struct {
+	*toml.MetaData
+}
+
+func (k tomlKeys) Keys() [][]string {
+	keys := [][]string{}
+	for _, key := range k.MetaData.Keys() {
+		keys = append(keys, []string(key))
+	}
+	return keys
+}
+
+//Parse a TOML-formatted string into a configuration struct.
+func ParseString(data string) (*Configuration, KeySet) {
+	set, keys, err := TomlFormat{}.Decode(data)
+	if err != nil { ExitGently("Could not decode file:", err) }
+	return set, keys
+}
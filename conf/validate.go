@@ -0,0 +1,190 @@
+package conf
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//A problem with a config file
+type ConfigProblem struct {
+	//Which file, and where in it (zero if the line isn't known)
+	File        string
+	Line        int
+
+	//Dotted path of the key at fault, and which entry of it (-1 for the whole key)
+	Key         string
+	Element     int
+
+	Message     string
+
+	//Fatal problems stop hroot; the rest are warnings
+	Fatal       bool
+}
+
+func (p ConfigProblem) String() string {
+	where := p.File
+	if p.Line > 0 {
+		where += ":" + strconv.Itoa(p.Line)
+	}
+	if p.Key == "" {
+		return where + ": " + p.Message
+	}
+	key := p.Key
+	if p.Element >= 0 {
+		key += "[" + strconv.Itoa(p.Element) + "]"
+	}
+	return where + ": " + key + ": " + p.Message
+}
+
+/*
+	Checks the values from one config file (before it's merged with any others) for anything hroot can't use.
+	The problems found don't say which file or line they're from; the parser fills that in.
+*/
+func validateConfiguration(c *Configuration) []ConfigProblem {
+	problems := []ConfigProblem{}
+	fatal := func(key string, element int, a ...interface{}) {
+		problems = append(problems, ConfigProblem{
+			Key:     key,
+			Element: element,
+			Message: fmt.Sprint(a...),
+			Fatal:   true,
+		})
+	}
+	warn := func(key string, element int, a ...interface{}) {
+		problems = append(problems, ConfigProblem{
+			Key:     key,
+			Element: element,
+			Message: fmt.Sprint(a...),
+		})
+	}
+
+	if c.Image.Upstream != "" && c.Image.Index != "" {
+		fatal("image.index", -1, "cannot define 'index' and 'upstream' in the same file; use separate config files to produce different images")
+	}
+	validateCopies(fatal, "image.copy", c.Image.Copies)
+	for i, from := range c.Image.From {
+		if len(from) < 3 || len(from) > 4 {
+			fatal("image.from", i, "files from another image need the image, a path in it, and a container path, and may have a version hash; found ", len(from), " values")
+		} else if from[0] == "" || from[1] == "" || from[2] == "" {
+			fatal("image.from", i, "the image and paths can't be empty")
+		} else if !strings.HasPrefix(from[1], "/") || !strings.HasPrefix(from[2], "/") {
+			fatal("image.from", i, "both paths must be absolute")
+		}
+	}
+
+	//Profiles have settings and targets of their own, held to the same rules
+	settings := map[string]Container{ "settings": c.Settings }
+	targets := map[string]map[string]Container{ "target": c.Targets }
+	for name, profile := range c.Profiles {
+		settings["profile."+name+".settings"] = profile.Settings
+		targets["profile."+name+".target"] = profile.Targets
+	}
+
+	for prefix, container := range settings {
+		if container.Extends != "" {
+			fatal(prefix+".extends", -1, "only targets can extend other targets")
+		}
+		//Only fatal to commands that run a container; the rest can get on with it
+		if len(container.Command) > 0 {
+			warn(prefix+".command", -1, "cannot specify a command in settings; instead, put it in a target")
+		}
+		if len(container.Steps) > 0 {
+			warn(prefix+".step", -1, "cannot specify steps in settings; instead, put them in a target")
+		}
+	}
+	for prefix, group := range targets {
+		for name, target := range group {
+			if target.Extends == name {
+				fatal(prefix+"."+name+".extends", -1, "a target can't extend itself")
+			}
+			if len(target.Command) > 0 && len(target.Steps) > 0 {
+				fatal(prefix+"."+name+".step", -1, "a target runs either a command or steps, not both")
+			}
+			for i, step := range target.Steps {
+				if len(step.Command) == 0 {
+					fatal(prefix+"."+name+".step", i, "a step needs a command")
+				}
+				for _, env := range step.Environment {
+					if len(env) != 2 || env[0] == "" {
+						fatal(prefix+"."+name+".step", i, "a step's environment variables each need a name and a value")
+					}
+				}
+			}
+		}
+	}
+
+	containers := map[string]Container{}
+	for prefix, container := range settings {
+		containers[prefix] = container
+	}
+	for prefix, group := range targets {
+		for name, target := range group {
+			containers[prefix+"."+name] = target
+		}
+	}
+	for prefix, container := range containers {
+		for i, list := range container.Replace {
+			if list != "mounts" && list != "ports" && list != "dns" && list != "environment" && list != "copy" && list != "artifacts" {
+				fatal(prefix+".replace", i, "only mounts, ports, dns, environment, copy, and artifacts can be replaced, not ", strconv.Quote(list))
+			}
+		}
+		for i, mount := range container.Mounts {
+			if len(mount) != 3 {
+				fatal(prefix+".mounts", i, "a mount needs a host folder, a container folder, and \"ro\" or \"rw\"; found ", len(mount), " values")
+			} else if mount[0] == "" || mount[1] == "" {
+				fatal(prefix+".mounts", i, "a mount's folders can't be empty")
+			} else if mount[2] != "ro" && mount[2] != "rw" {
+				fatal(prefix+".mounts", i, "a mount must be \"ro\" or \"rw\", not ", strconv.Quote(mount[2]))
+			}
+		}
+		for i, port := range container.Ports {
+			if len(port) != 2 {
+				fatal(prefix+".ports", i, "a port needs a host port and a container port; found ", len(port), " values")
+			}
+		}
+		for i, env := range container.Environment {
+			if len(env) != 2 {
+				fatal(prefix+".environment", i, "an environment variable needs a name and a value; found ", len(env), " values")
+			} else if env[0] == "" {
+				fatal(prefix+".environment", i, "an environment variable's name can't be empty")
+			}
+		}
+		validateCopies(fatal, prefix+".copy", container.Copies)
+		for i, artifact := range container.Artifacts {
+			if len(artifact) != 2 {
+				fatal(prefix+".artifacts", i, "an artifact needs a container path and a host path; found ", len(artifact), " values")
+			} else if artifact[0] == "" || artifact[1] == "" {
+				fatal(prefix+".artifacts", i, "an artifact's paths can't be empty")
+			} else if !strings.HasPrefix(artifact[0], "/") {
+				fatal(prefix+".artifacts", i, "an artifact's container path must be absolute, not ", strconv.Quote(artifact[0]))
+			}
+		}
+	}
+	return problems
+}
+
+//Checks each copy has a host path, an absolute container path, and if given, a numeric owner and an octal mode.
+func validateCopies(fatal func(string, int, ...interface{}), key string, copies [][]string) {
+	for i, entry := range copies {
+		if len(entry) < 2 || len(entry) > 4 {
+			fatal(key, i, "a copy needs a host path and a container path, and may have an owner and a mode; found ", len(entry), " values")
+			continue
+		}
+		if entry[0] == "" || entry[1] == "" {
+			fatal(key, i, "a copy's paths can't be empty")
+		} else if !strings.HasPrefix(entry[1], "/") {
+			fatal(key, i, "a copy's container path must be absolute, not ", strconv.Quote(entry[1]))
+		}
+		if len(entry) > 2 && entry[2] != "" {
+			if _, _, err := ParseOwner(entry[2]); err != nil {
+				fatal(key, i, err)
+			}
+		}
+		if len(entry) > 3 && entry[3] != "" {
+			if _, err := ParseMode(entry[3]); err != nil {
+				fatal(key, i, err)
+			}
+		}
+	}
+}
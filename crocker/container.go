@@ -1,6 +1,7 @@
 package crocker
 
 import (
+	"encoding/json"
 	"io"
 	"os"
 	"strings"
@@ -93,6 +94,21 @@ func (c *Container) Wait() {
 	c.dock.Cmd()("wait", c.id)()
 }
 
+/*
+	Returns the exit code of the container's main process, once it has exited.
+*/
+func (c *Container) ExitCode() int {
+	var info struct {
+		State struct {
+			ExitCode int
+		}
+	}
+	data, _ := c.dock.Call("GET", "/containers/" + c.id + "/json", nil)
+	err := json.Unmarshal(data, &info)
+	if err != nil { ExitGently("Docker API error:", err.Error()) }
+	return info.State.ExitCode
+}
+
 /*
 	Discards the container state and filesystem (i.e., wraps `docker rm`).
 
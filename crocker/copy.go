@@ -0,0 +1,207 @@
+package crocker
+
+import (
+	"archive/tar"
+	. "fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	. "polydawn.net/hroot/util"
+)
+
+/*
+	A file or folder to copy from the host into an image.
+	Folders are copied with everything in them.
+*/
+type FileCopy struct {
+	//Absolute path on the host
+	From string
+
+	//Absolute path in the image
+	To   string
+
+	//Who owns the copied files in the image
+	Uid  int
+	Gid  int
+
+	//Permissions for copied files; zero keeps the host's.  Folders always keep the host's.
+	Mode os.FileMode
+}
+
+/*
+	Makes a new image from an existing one, with more files in it (i.e., wraps `docker build` with a Dockerfile that ADDs a tar of them).
+	The files function writes them to the tar, named by where they go in the image; later entries win.
+	Because the files are part of the image, anything run from it sees them, and an export of the container includes them.
+*/
+func (dock *Dock) BuildWithFiles(image string, name string, files func(fs *tar.Writer)) {
+	dir, err := ioutil.TempDir("", "hroot-copy-")
+	if err != nil { ExitGently("Cannot create a build folder:", err) }
+	defer os.RemoveAll(dir)
+
+	//Docker unpacks a tar it's asked to ADD, keeping the owners and modes in it
+	out, err := os.Create(filepath.Join(dir, "copy.tar"))
+	if err != nil { ExitGently("Cannot create a build folder:", err) }
+	fs := tar.NewWriter(out)
+	files(fs)
+	err = fs.Close()
+	if err == nil {
+		err = out.Close()
+	}
+	if err != nil { ExitGently("Cannot create a build folder:", err) }
+
+	// Docker really hates its own domain. I know, whatever.
+	nameTemp := strings.Replace(image, "docker.io", "docker.IO", -1)
+
+	dockerfile := "FROM " + nameTemp + "\n" + "ADD copy.tar /\n"
+	err = ioutil.WriteFile(filepath.Join(dir, "Dockerfile"), []byte(dockerfile), 0644)
+	if err != nil { ExitGently("Cannot create a build folder:", err) }
+
+	dock.Cmd()("build", "--rm", "-t", name, dir)()
+}
+
+//Writes files from the host into a tar, laid out the way they go in the image.
+func WriteCopies(fs *tar.Writer, copies []FileCopy) {
+	for _, copy := range copies {
+		err := filepath.Walk(copy.From, func(file string, info os.FileInfo, err error) error {
+			if err != nil { return err }
+
+			link := ""
+			if info.Mode() & os.ModeSymlink != 0 {
+				link, err = os.Readlink(file)
+				if err != nil { return err }
+			}
+			header, err := tar.FileInfoHeader(info, link)
+			if err != nil { return err }
+
+			//Same place relative to the destination as to the source
+			rel, err := filepath.Rel(copy.From, file)
+			if err != nil { return err }
+			header.Name = strings.TrimPrefix(filepath.Join(copy.To, rel), "/")
+			if info.IsDir() {
+				header.Name += "/"
+			}
+			header.Uid, header.Gid = copy.Uid, copy.Gid
+			header.Uname, header.Gname = "", ""
+			if copy.Mode != 0 && info.Mode().IsRegular() {
+				header.Mode = header.Mode &^ 0777 | int64(copy.Mode.Perm())
+			}
+
+			if err := fs.WriteHeader(header); err != nil { return err }
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+
+			in, err := os.Open(file)
+			if err != nil { return err }
+			defer in.Close()
+			_, err = io.Copy(fs, in)
+			return err
+		})
+		if err != nil { ExitGently("Cannot copy", copy.From + ":", err) }
+	}
+}
+
+/*
+	Copies a file or folder out of the container to the host (i.e., wraps `docker cp`).
+	The copy lands at exactly the host path, replacing anything in the way; folders come with everything in them.
+	Returns false, copying nothing, if the container has nothing at that path.
+*/
+func (c *Container) CopyOut(resource string, hostPath string) bool {
+	resp := c.dock.request("POST", "/containers/" + c.id + "/copy", APICopy{
+		Resource: resource,
+		HostPath: hostPath,
+	})
+	defer resp.Body.Close()
+
+	//Docker answers a missing path with 404 these days, and with a 500 that says so before that
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode == http.StatusNotFound && !strings.Contains(string(body), "No such container") {
+			return false
+		} else if strings.Contains(string(body), "Could not find the file") {
+			return false
+		}
+		ExitGently("Could not copy", resource, "out of the container:", strings.TrimSpace(string(body)))
+	}
+
+	readCopyTar(tar.NewReader(resp.Body), hostPath)
+	return true
+}
+
+//Unpacks a tar from docker's copy, whose entries are all under the name of what was copied, to the host path.
+func readCopyTar(fs *tar.Reader, hostPath string) {
+	err := os.RemoveAll(hostPath)
+	if err != nil { ExitGently("Cannot replace", hostPath + ":", err) }
+	err = os.MkdirAll(filepath.Dir(hostPath), 0755)
+	if err != nil { ExitGently("Cannot create", filepath.Dir(hostPath) + ":", err) }
+
+	//Symlinks from the container can point anywhere, so nothing is written through one that leaves the copy
+	dir, err := filepath.EvalSymlinks(filepath.Dir(hostPath))
+	if err != nil { ExitGently("Cannot create", filepath.Dir(hostPath) + ":", err) }
+	root := filepath.Join(dir, filepath.Base(hostPath))
+
+	for {
+		header, err := fs.Next()
+		if err == io.EOF { break }
+		if err != nil { ExitGently("Could not read copy from docker:", err) }
+
+		path := copiedPath(header.Name, hostPath)
+		mode := os.FileMode(header.Mode).Perm()
+		if path != hostPath && !insideCopy(path, root) {
+			ExitGently("Cannot write", path + ": a symlink in the copy leads outside of", hostPath)
+		}
+
+		switch header.Typeflag {
+			case tar.TypeDir:
+				err = os.MkdirAll(path, mode | 0700)
+			case tar.TypeReg, tar.TypeRegA:
+				var out *os.File
+				out, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+				if err == nil {
+					_, err = io.Copy(out, fs)
+					out.Close()
+				}
+			case tar.TypeSymlink:
+				err = os.Symlink(header.Linkname, path)
+			case tar.TypeLink:
+				target := copiedPath(header.Linkname, hostPath)
+				if target != hostPath && !insideCopy(target, root) {
+					ExitGently("Cannot link", path, "to", target + ": a symlink in the copy leads outside of", hostPath)
+				}
+				err = os.Link(target, path)
+			default:
+				//Devices and the like have no business being artifacts
+				Println("Skipping", header.Name, "- only files, folders, and symlinks are copied out.")
+		}
+		if err != nil { ExitGently("Cannot write", path + ":", err) }
+	}
+}
+
+//Where an entry from docker's copy goes: the copied thing's own name is swapped for the host path.
+func copiedPath(name string, hostPath string) string {
+	parts := strings.SplitN(strings.Trim(filepath.Clean("/" + name), "/"), "/", 2)
+	if len(parts) == 2 {
+		return filepath.Join(hostPath, parts[1])
+	}
+	return hostPath
+}
+
+//Checks that the folder a path is in, once symlinks are followed, is still in the copy at root.
+func insideCopy(path string, root string) bool {
+	//Folders that aren't there yet can't be symlinks, so it's the nearest one that is that counts
+	dir := filepath.Dir(path)
+	for {
+		resolved, err := filepath.EvalSymlinks(dir)
+		if err == nil {
+			return resolved == root || strings.HasPrefix(resolved, root + string(filepath.Separator))
+		}
+		if _, lerr := os.Lstat(dir); lerr == nil || !os.IsNotExist(err) || dir == filepath.Dir(dir) {
+			//Something there that won't resolve, like a symlink to nowhere, or nothing left to check
+			return false
+		}
+		dir = filepath.Dir(dir)
+	}
+}
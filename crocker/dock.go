@@ -121,6 +121,31 @@ func (dock *Dock) Cmd() Command {
 func (dock *Dock) Call(method, path string, data interface{}) ([]byte, int) {
 	// Print network traffic to terminal if DEBUG env var exists
 	networkDebug := (len(os.Getenv("DEBUG")) > 0)
+
+	resp := dock.request(method, path, data)
+
+	//Read in response
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil { ExitGently("Could not read response: " + err.Error()) }
+
+	//Check error code
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+			if len(body) == 0 {
+					ExitGently("Bad return: " + http.StatusText(resp.StatusCode))
+			}
+			ExitGently("Bad return: " + string(body))
+	}
+
+	if (networkDebug) { Println("Network: " + string(body)) }
+
+	return body, resp.StatusCode
+}
+
+// Hit the docker daemon with an HTTP request, returns the response for streaming; the caller checks its status and closes its body
+func (dock *Dock) request(method, path string, data interface{}) *http.Response {
+	// Print network traffic to terminal if DEBUG env var exists
+	networkDebug := (len(os.Getenv("DEBUG")) > 0)
 	if (networkDebug) { Println("Calling: " + method + " " + path) }
 
 	//Encode data if needed
@@ -155,20 +180,5 @@ func (dock *Dock) Call(method, path string, data interface{}) ([]byte, int) {
 			ExitGently("Couldn't connect to docker: " + err.Error())
 	}
 
-	//Read in response
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil { ExitGently("Could not read response: " + err.Error()) }
-
-	//Check error code
-	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
-			if len(body) == 0 {
-					ExitGently("Bad return: " + http.StatusText(resp.StatusCode))
-			}
-			ExitGently("Bad return: " + string(body))
-	}
-
-	if (networkDebug) { Println("Network: " + string(body)) }
-
-	return body, resp.StatusCode
+	return resp
 }
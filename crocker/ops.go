@@ -16,6 +16,13 @@ func (dock *Dock) Pull(image string) {
 	dock.Cmd()("pull", image)()
 }
 
+/*
+	Removes an image from docker's cache (i.e., wraps `docker rmi`).
+*/
+func (dock *Dock) RemoveImage(image string) {
+	dock.Cmd()("rmi", image)()
+}
+
 /*
 	Import an image into repository, caching the expanded form so that it's
 	ready to be used as a base filesystem for containers.
@@ -69,6 +76,21 @@ func (dock *Dock) CheckCache(image string) bool {
 	return false
 }
 
+// Get the ID of an image in docker's cache, which changes whenever its contents do.
+func (dock *Dock) ImageID(image string) string {
+	var info struct {
+		ID string `json:"Id"`
+	}
+
+	// Docker really hates its own domain. I know, whatever.
+	nameTemp := strings.Replace(image, "docker.io", "docker.IO", -1)
+
+	data, _ := dock.Call("GET", "/images/" + nameTemp + "/json", nil)
+	err := json.Unmarshal(data, &info)
+	if err != nil { ExitGently("Docker API error:", err.Error()) }
+	return info.ID
+}
+
 // Run the simple docker version command for debugging
 func (dock *Dock) PrintVersion() {
 	dock.Cmd()("version")()
@@ -0,0 +1,73 @@
+package dex
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	. "polydawn.net/pogo/gosh"
+	. "polydawn.net/hroot/crocker"
+	"polydawn.net/hroot/util"
+)
+
+/*
+	Writes a git bundle of the given lineages and their tags, or of every lineage if none are given.
+	The bundle also carries the hroot/init marker, so it can be cloned into a graph of its own.
+
+	If a base version is given, the bundle leaves out everything the base already has,
+	so it can only be loaded into a graph that has the base.
+
+	The file path should be absolute, since git runs from the graph's dir.
+*/
+func (g *Graph) Bundle(file string, base string, lineages ...string) {
+	if len(lineages) == 0 {
+		lineages = g.Lineages()
+	}
+	if len(lineages) == 0 {
+		util.ExitGently("No images in graph to bundle.")
+	}
+
+	refs := []string{ git_branch_ref_prefix+hroot_ref_prefix+"init" }
+	for _, lineage := range lineages {
+		lineage, _ = SplitImageName(lineage)
+		if !g.HasBranch(hroot_image_ref_prefix+lineage) {
+			util.ExitGently("Image branch name", lineage, "not found in graph.")
+		}
+		refs = append(refs, git_branch_ref_prefix+hroot_image_ref_prefix+lineage)
+		for _, tag := range g.tagsOf(lineage) {
+			refs = append(refs, hroot_tag_ref_prefix+lineage+"/"+tag[1])
+		}
+	}
+
+	bundle := g.cmd(NullIO)("bundle", "create", file)
+	for _, ref := range refs {
+		bundle = bundle(ref)
+	}
+	if base != "" {
+		func() {
+			defer func() {
+				if recover() != nil {
+					util.ExitGently("Version", base, "not found in graph.")
+				}
+			}()
+			base = g.versionOf(base)
+		}()
+		bundle = bundle("^"+base)
+	}
+
+	fmt.Println("Bundling", strings.Join(lineages, ", "), "into", file)
+	if !succeeds(bundle) {
+		util.ExitGently("Nothing to bundle; the base already has every version of", strings.Join(lineages, ", "))
+	}
+}
+
+/*
+	Checks a bundle made by Bundle, then pulls its images into the graph, exactly as Pull does.
+	The graph must already have everything the bundle was based on.
+*/
+func (g *Graph) LoadBundle(file string) {
+	var verify bytes.Buffer
+	if !g.gitTest(Opts{Out: &verify, Err: &verify}, "bundle", "verify", file) {
+		util.ExitGently("Cannot load bundle", file + ":\n" + strings.TrimSpace(verify.String()))
+	}
+	g.Pull(file)
+}
@@ -0,0 +1,64 @@
+package dex
+
+import (
+	"path/filepath"
+	"testing"
+	"github.com/coocood/assrt"
+)
+
+func TestBundleRoundTrip(t *testing.T) {
+	do(func() {
+		assert := assrt.NewAssert(t)
+
+		upstream := NewGraph("upstream")
+		publishSomeHistory(upstream)
+		upstream.Tag("line", "stable", upstream.versions("line")[1])
+
+		bundle, _ := filepath.Abs("line.bundle")
+		upstream.Bundle(bundle, "", "line")
+
+		g := NewGraph("graph")
+		g.LoadBundle(bundle)
+		assert.Equal([]string{ "line" }, g.Lineages())
+		assert.Equal(upstream.versions("line"), g.versions("line"))
+		assert.Equal(upstream.versionOf(hroot_tag_ref_prefix+"line/stable"), g.versionOf(hroot_tag_ref_prefix+"line/stable"))
+
+		// a bundle can also be cloned straight into a graph of its own.
+		clone, _ := filepath.Abs("clone")
+		g.cmd("clone", "--bare", "--quiet", bundle, clone)()
+		assert.NotNil(LoadGraph(clone))
+	})
+}
+
+func TestIncrementalBundle(t *testing.T) {
+	do(func() {
+		assert := assrt.NewAssert(t)
+
+		upstream := NewGraph("upstream")
+		publishSomeHistory(upstream)
+		g := NewGraph("graph")
+		g.Pull(upstream.dir)
+
+		base := upstream.versions("line")[0]
+		newest := upstream.Publish(
+			"line",
+			"line",
+			&GraphStoreRequest_Tar{
+				Tarstream: fsSetB(),
+			},
+		)
+
+		bundle, _ := filepath.Abs("line.bundle")
+		upstream.Bundle(bundle, base, "line")
+		g.LoadBundle(bundle)
+		assert.Equal(newest, g.versionOf(git_branch_ref_prefix+hroot_image_ref_prefix+"line"))
+
+		// a graph that doesn't have the base can't load it.
+		empty := NewGraph("empty")
+		defer func() {
+			err := recover()
+			if err == nil { t.Fail(); }
+		}()
+		empty.LoadBundle(bundle)
+	})
+}
@@ -1,13 +1,16 @@
 package dex
 
 import (
+	"archive/tar"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	. "polydawn.net/pogo/gosh"
 	. "polydawn.net/hroot/crocker"
 	"polydawn.net/hroot/util"
+	"polydawn.net/guitar/stream"
 	"strings"
 )
 
@@ -21,6 +24,16 @@ type Graph struct {
 		Cached command template for exec'ing git with this graph's cwd.
 	*/
 	cmd Command
+
+	/*
+		GPG key to sign new commits with, if any.
+	*/
+	signingKey string
+
+	/*
+		File of trusted public keys, if any.  When set, loads and pulls require commits signed by one of these keys.
+	*/
+	keyring string
 }
 
 // strap this in only sometimes -- some git commands need this prefix to be explicit about branches instead of tags; others refuse it because they're already forcibly about branches.
@@ -28,6 +41,13 @@ const git_branch_ref_prefix = "refs/heads/"
 const hroot_ref_prefix = "hroot/"
 const hroot_image_ref_prefix = hroot_ref_prefix+"image/"
 
+// image tags are real git tags, namespaced by lineage: "refs/tags/hroot/<lineage>/<tag>".
+const git_tag_ref_prefix = "refs/tags/"
+const hroot_tag_ref_prefix = git_tag_ref_prefix+hroot_ref_prefix
+
+// holding area for refs fetched by a pull that haven't been checked yet.
+const hroot_pull_ref_prefix = "refs/hroot-pull/"
+
 /*
 	Loads a Graph if there is a git repo initialized at the given dir; returns nil if a graph repo not found.
 	The dir must be the root of the working tree of the git dir.
@@ -111,6 +131,22 @@ func (g *Graph) isRepoRoot() (v bool) {
 	return
 }
 
+/*
+	Sign every commit this graph makes with the given gpg key.
+	An empty key turns signing off.
+*/
+func (g *Graph) SignWith(key string) {
+	g.signingKey = key
+}
+
+/*
+	Require a good signature from a key in the given keyring on anything this graph loads or pulls.
+	An empty keyring turns verification off.
+*/
+func (g *Graph) RequireSignatures(keyring string) {
+	g.keyring = keyring
+}
+
 //Is git ready and configured to make commits?
 func (g *Graph) IsConfigReady() bool {
 	//Get the current git configuration
@@ -157,10 +193,20 @@ func (g *Graph) withTempTree(fn func(cmd Command)) {
 	fn(gt)
 }
 
-func (g *Graph) Publish(lineage string, ancestor string, gr GraphStoreRequest) (hash string) {
-	// Handle tags - currently, we discard them when dealing with a graph repo.
-	lineage, _  = SplitImageName(lineage)
-	ancestor, _ = SplitImageName(ancestor)
+/*
+	Publishes a new version of an image, built from the ancestor image (or from nothing, if ancestor is empty).
+
+	The commit always lands on the image's lineage branch.
+	If the image name carries a tag (other than "latest"), the tag is created or moved to point at the new commit.
+	If the ancestor name carries a tag, the tagged version is used as the parent, rather than the newest version of the ancestor lineage.
+	Any trailers ("Key: value" lines) are added to the end of the commit message.
+*/
+func (g *Graph) Publish(lineage string, ancestor string, gr GraphStoreRequest, trailers ...string) (hash string) {
+	// Handle tags - the commit goes on the lineage branch, and the tag is pointed at it after.
+	lineage, tag := SplitImageName(lineage)
+	if ancestorLineage, ancestorTag := SplitImageName(ancestor); ancestorTag == DefaultTag {
+		ancestor = ancestorLineage
+	}
 
 	g.withTempTree(func(cmd Command) {
 		fmt.Println("Starting publish of ", lineage, " <-- ", ancestor)
@@ -177,42 +223,191 @@ func (g *Graph) Publish(lineage string, ancestor string, gr GraphStoreRequest) (
 				g.cmd("checkout", "--orphan", hroot_image_ref_prefix+lineage)()
 			} else {
 				fmt.Println("New lineage!  Forking it from ancestor branch.")
-				g.cmd("branch", hroot_image_ref_prefix+lineage, hroot_image_ref_prefix+ancestor)()
+				g.cmd("branch", hroot_image_ref_prefix+lineage, g.imageRef(ancestor))()
 				g.cmd("symbolic-ref", "HEAD", git_branch_ref_prefix+hroot_image_ref_prefix+lineage)()
 			}
 		}
 		g.cmd("reset")
 
-		// apply the GraphStoreRequest to unpack the fs (read from fs.tarReader, essentially)
-		gr.place(".")
+		// have guitar unpack the fs the GraphStoreRequest provides
+		err := stream.ExportToFilesystem(gr.StoreTar(), ".", gr.StoreSettings())
+		if err != nil { panic(err); }
 
 		// exec git add, tree write, merge, commit.
 		g.cmd("add", "--all")()
-		g.forceMerge(ancestor, lineage)
+		g.forceMerge(ancestor, lineage, trailers...)
 
-		hash = ""	//FIXME
+		hash = g.versionOf(git_branch_ref_prefix+hroot_image_ref_prefix+lineage)
 	})
+
+	if tag != DefaultTag {
+		g.setTag(lineage, tag, hash)
+	}
 	return
 }
 
 func (g *Graph) Load(lineage string, gr GraphLoadRequest) (hash string) {
-	lineage, _ = SplitImageName(lineage) //Handle tags
+	return g.LoadVersion(lineage, "", gr)
+}
+
+/*
+	Loads a specific version of a lineage, identified by commit hash.
+	An empty version loads the version the image name refers to, same as Load:
+	the tagged version if the name carries a tag, otherwise the latest commit on the lineage.
+
+	The commit must belong to the lineage: its message must start with the lineage name.
+*/
+func (g *Graph) LoadVersion(image string, version string, gr GraphLoadRequest) (hash string) {
+	hash = g.loadableVersion(image, version)
+	if err := g.loadTree(hash, gr); err != nil {
+		panic(err)
+	}
+	return
+}
+
+/*
+	Finds the commit LoadVersion would load, without loading it.
+	Exits gently if there's no such image or version, or if it isn't signed by the keyring.
+*/
+func (g *Graph) loadableVersion(image string, version string) string {
+	lineage, tag := SplitImageName(image) //Handle tags
 
 	//Check if the image is in the graph so we can generate a relatively friendly error message
 	if !g.HasBranch(hroot_image_ref_prefix+lineage) {	//HALP
 		util.ExitGently("Image branch name", lineage, "not found in graph.")
 	}
 
+	ref := g.imageRef(image)
+	if version != "" {
+		if tag != DefaultTag {
+			util.ExitGently("Cannot load", image, "by both tag and hash.")
+		}
+		ref = g.resolveVersion(lineage, version)
+	}
+
+	//Refuse to hand over anything we don't trust
+	if g.keyring != "" {
+		g.withKeyring(func(env Env) {
+			g.requireSignature(env, ref)
+		})
+	}
+
+	return g.versionOf(ref)
+}
+
+/*
+	Hands the filesystem of a commit to a GraphLoadRequest.
+	Returns the error guitar had reading the filesystem back, if it did; the gr sees it as the end of the tarstream.
+*/
+func (g *Graph) loadTree(hash string, gr GraphLoadRequest) (err error) {
 	g.withTempTree(func(cmd Command) {
 		// checkout lineage.
 		// "-f" because otherwise if git thinks we already had this branch checked out, this working tree is just chock full of deletes.
-		g.cmd("checkout", "-f", git_branch_ref_prefix+hroot_image_ref_prefix+lineage)()
+		g.cmd("checkout", "-f", hash)()
+
+		// have guitar read this filesystem as a tarstream; the gr consumes it and shoves it at whoever it deals with.
+		loadReader, loadWriter := io.Pipe()
+		loaded := make(chan error, 1)
+		go func() {
+			err := stream.ImportFromFilesystem(tar.NewWriter(loadWriter), ".")
+			loadWriter.CloseWithError(err)
+			loaded <- err
+		}()
+		gr.LoadTar(tar.NewReader(loadReader))
+
+		// if the gr didn't read everything, don't leave guitar hanging.
+		loadReader.Close()
+
+		// and wait for guitar to be done with the tree before it's cleaned up; it only fails on a closed pipe if the gr stopped early.
+		if err = <-loaded; err == io.ErrClosedPipe {
+			err = nil
+		}
+	})
+	return
+}
 
-		// the gr consumes this filesystem and shoves it at whoever it deals with; we're actually hands free after handing over a dir.
-		gr.receive(".")
+/*
+	Points an image tag at a version of the image.
+	The version is picked by hash if one is given, otherwise by the image name (which may carry a tag of its own).
+	Returns the full hash that was tagged.
+*/
+func (g *Graph) Tag(image string, tag string, version string) (hash string) {
+	lineage, _ := SplitImageName(image)
+	if !g.HasBranch(hroot_image_ref_prefix+lineage) {
+		util.ExitGently("Image branch name", lineage, "not found in graph.")
+	}
 
-		hash = ""	//FIXME
-	})
+	if version != "" {
+		hash = g.resolveVersion(lineage, version)
+	} else {
+		hash = g.versionOf(g.imageRef(image))
+	}
+
+	g.setTag(lineage, tag, hash)
+	return
+}
+
+//Creates or moves an image tag.
+func (g *Graph) setTag(lineage string, tag string, hash string) {
+	if tag == DefaultTag {
+		util.ExitGently("The", DefaultTag, "tag always means the newest version of an image; it can't be set.")
+	}
+
+	ref := hroot_tag_ref_prefix+lineage+"/"+tag
+	func() {
+		defer func() {
+			if recover() != nil {
+				util.ExitGently("Cannot use", tag, "as a tag name.")
+			}
+		}()
+		g.cmd(NullIO)("check-ref-format", ref)()
+	}()
+
+	fmt.Println("Tagging", lineage+":"+tag, "as", hash)
+	g.cmd("update-ref", ref, hash)()
+}
+
+/*
+	Returns the ref an image name refers to: its tag, if it has one, otherwise its lineage branch.
+	Exits if the tag doesn't exist.
+*/
+func (g *Graph) imageRef(image string) string {
+	lineage, tag := SplitImageName(image)
+	if tag == DefaultTag {
+		return git_branch_ref_prefix+hroot_image_ref_prefix+lineage
+	}
+
+	ref := hroot_tag_ref_prefix+lineage+"/"+tag
+	if !g.hasRef(ref) {
+		util.ExitGently("Image tag", image, "not found in graph.")
+	}
+	return ref
+}
+
+//Returns the full commit hash a ref points to.
+func (g *Graph) versionOf(ref string) string {
+	return strings.Trim(g.cmd(NullIO)("rev-parse", ref+"^{commit}").Output(), "\n")
+}
+
+/*
+	Resolves a (possibly abbreviated) commit hash to a full hash, and checks that it belongs to the given lineage.
+	Per the commit message convention below, the first word of a commit's subject is the lineage name.
+*/
+func (g *Graph) resolveVersion(lineage string, version string) (hash string) {
+	func() {
+		defer func() {
+			// rev-parse exits non-zero if the hash doesn't exist or is ambiguous.
+			if recover() != nil {
+				util.ExitGently("Version", version, "not found in graph.")
+			}
+		}()
+		hash = strings.Trim(g.cmd(NullIO)("rev-parse", "--verify", version+"^{commit}").Output(), "\n")
+	}()
+
+	subject := strings.Fields(g.cmd(NullIO)("log", "-1", "--format=%s", hash).Output())
+	if len(subject) == 0 || subject[0] != lineage {
+		util.ExitGently("Version", version, "is not a version of image", lineage)
+	}
 	return
 }
 
@@ -227,26 +422,178 @@ func (g *Graph) Load(lineage string, gr GraphLoadRequest) (hash string) {
 //            - we won't validate any of this if you're not using load-by-hash.
 
 
-func (g *Graph) forceMerge(source string, target string) {
+func (g *Graph) forceMerge(source string, target string, trailers ...string) {
 	writeTree := g.cmd("write-tree").Output()
 	writeTree = strings.Trim(writeTree, "\n")
 	commitMsg := ""
+	parents := []string{}
 	if source == "" {
 		commitMsg = fmt.Sprintf("%s imported from an external source", target)
 	} else {
 		commitMsg = fmt.Sprintf("%s updated from %s", target, source)
+		parents = append(parents, g.imageRef(source), git_branch_ref_prefix+hroot_image_ref_prefix+target)
 	}
-	commitTreeCmd := g.cmd("commit-tree", writeTree, Opts{In: commitMsg})
-	if source != "" {
-		commitTreeCmd = commitTreeCmd(
-			"-p", git_branch_ref_prefix+hroot_image_ref_prefix+source,
-			"-p", git_branch_ref_prefix+hroot_image_ref_prefix+target,
-		)
+	if len(trailers) > 0 {
+		commitMsg += "\n\n" + strings.Join(trailers, "\n")
 	}
-	mergeTree := strings.Trim(commitTreeCmd.Output(), "\n")
+	mergeTree := g.commitTree(writeTree, commitMsg, parents...)
 	g.cmd("merge", "-q", mergeTree)()
 }
 
+//Makes a commit of a tree with the given parents, signed if the graph signs commits.  Returns the new commit's hash.
+func (g *Graph) commitTree(tree string, message string, parents ...string) string {
+	commitTreeCmd := g.cmd("commit-tree", tree, Opts{In: message})
+	if g.signingKey != "" {
+		commitTreeCmd = commitTreeCmd("-S"+g.signingKey)
+	}
+	for _, parent := range parents {
+		commitTreeCmd = commitTreeCmd("-p", parent)
+	}
+	return strings.Trim(commitTreeCmd.Output(), "\n")
+}
+
+/*
+	Fetches images from another graph, by git url.
+	Pulls the given lineages and their tags, or every lineage if none are given.
+
+	Lineages that already exist here must fast-forward; tags are moved to match the other graph.
+	If the graph requires signatures, every incoming version must be signed by a trusted key, or nothing is pulled.
+*/
+func (g *Graph) Pull(url string, lineages ...string) {
+	// fetch into a holding area first, so nothing lands before it's been checked.
+	refspecs := []string{}
+	if len(lineages) == 0 {
+		refspecs = append(refspecs,
+			"+"+git_branch_ref_prefix+hroot_image_ref_prefix+"*:"+hroot_pull_ref_prefix+"heads/"+hroot_image_ref_prefix+"*",
+			"+"+hroot_tag_ref_prefix+"*:"+hroot_pull_ref_prefix+"tags/"+hroot_ref_prefix+"*",
+		)
+	}
+	for _, lineage := range lineages {
+		lineage, _ = SplitImageName(lineage)
+		refspecs = append(refspecs,
+			"+"+git_branch_ref_prefix+hroot_image_ref_prefix+lineage+":"+hroot_pull_ref_prefix+"heads/"+hroot_image_ref_prefix+lineage,
+			"+"+hroot_tag_ref_prefix+lineage+"/*:"+hroot_pull_ref_prefix+"tags/"+hroot_ref_prefix+lineage+"/*",
+		)
+	}
+	defer g.clearPull()
+	func() {
+		defer func() {
+			if recover() != nil {
+				util.ExitGently("Could not fetch images from", url)
+			}
+		}()
+		fetch := g.cmd("fetch", "--no-tags", url)
+		for _, refspec := range refspecs {
+			fetch = fetch(refspec)
+		}
+		fetch()
+	}()
+
+	// see what we got; each line is "<hash> refs/hroot-pull/<heads or tags>/..."
+	incoming := [][]string{}
+	for _, line := range strings.Split(strings.Trim(g.cmd(NullIO)("for-each-ref", "--format=%(objectname) %(refname)", hroot_pull_ref_prefix).Output(), "\n"), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			incoming = append(incoming, []string{ fields[0], "refs/" + strings.TrimPrefix(fields[1], hroot_pull_ref_prefix) })
+		}
+	}
+	if len(incoming) == 0 {
+		util.ExitGently("No images found at", url)
+	}
+
+	// check everything before touching anything: every version that isn't here yet, not just the newest of each.
+	if g.keyring != "" {
+		unseen := g.cmd(NullIO)("rev-list")
+		for _, in := range incoming {
+			unseen = unseen(in[0])
+		}
+		versions := strings.Fields(unseen("--not", "--exclude="+hroot_pull_ref_prefix+"*", "--all").Output())
+		g.withKeyring(func(env Env) {
+			for _, hash := range versions {
+				g.requireSignature(env, hash)
+			}
+		})
+	}
+	for _, in := range incoming {
+		hash, ref := in[0], in[1]
+		if strings.HasPrefix(ref, git_branch_ref_prefix) && g.hasRef(ref) && !g.isAncestor(ref, hash) {
+			util.ExitGently("Cannot pull", strings.TrimPrefix(ref, git_branch_ref_prefix+hroot_image_ref_prefix), "- it has versions here that aren't in", url)
+		}
+	}
+
+	for _, in := range incoming {
+		fmt.Println("Pulled", in[1], "at", in[0])
+		g.cmd("update-ref", in[1], in[0])()
+	}
+}
+
+//Drops the holding area Pull fetches into.
+func (g *Graph) clearPull() {
+	for _, ref := range strings.Fields(g.cmd(NullIO)("for-each-ref", "--format=%(refname)", hroot_pull_ref_prefix).Output()) {
+		g.cmd("update-ref", "-d", ref)()
+	}
+}
+
+/*
+	Sets up a throwaway gpg home holding only the graph's trusted keyring, and hands over the environment for git to use it.
+	That way, "trusted" means exactly "in the keyring", no matter what the user's own gpg setup is.
+*/
+func (g *Graph) withKeyring(fn func(env Env)) {
+	home, err := ioutil.TempDir("", "hroot-keyring.")
+	if err != nil { panic(err); }
+	defer os.RemoveAll(home)
+
+	func() {
+		defer func() {
+			if recover() != nil {
+				util.ExitGently("Could not read trusted keyring", g.keyring)
+			}
+		}()
+		Sh("gpg")(NullIO)("--batch", "--homedir", home, "--import", g.keyring)()
+	}()
+
+	fn(Env{"GNUPGHOME": home})
+}
+
+//Exits unless the commit a ref points to has a good signature from the keyring set up by withKeyring.
+func (g *Graph) requireSignature(env Env, ref string) {
+	hash := g.versionOf(ref)
+	if !g.gitTest(env, "verify-commit", hash) {
+		util.ExitGently("Version", hash, "is not signed by a trusted key.")
+	}
+}
+
+//Is one commit an ancestor of (or the same as) another?
+func (g *Graph) isAncestor(ancestor string, descendant string) bool {
+	return g.gitTest("merge-base", "--is-ancestor", ancestor, descendant)
+}
+
+//Checks if the graph has a ref.
+func (g *Graph) hasRef(ref string) bool {
+	return len(g.cmd("ls-remote", ".", ref).Output()) > 0
+}
+
+//Runs a git command that answers a yes-or-no question with its exit code.
+func (g *Graph) gitTest(args ...interface{}) bool {
+	return succeeds(g.cmd(NullIO)(args...))
+}
+
+//Runs a command, reporting whether it exited cleanly rather than panicking if it didn't.
+func succeeds(cmd Command) (v bool) {
+	defer func() {
+		if recover() != nil {
+			v = false
+		}
+	}()
+	cmd()
+	return true
+}
+
+//Checks if the graph has a lineage for an image.
+func (g *Graph) HasLineage(lineage string) bool {
+	lineage, _ = SplitImageName(lineage) //Handle tags
+	return g.HasBranch(hroot_image_ref_prefix+lineage)
+}
+
 //Checks if the graph has a branch.
 func (g *Graph) HasBranch(branch string) bool {
 	//Git magic is involved. Response will be of non-zero length if branch exists.
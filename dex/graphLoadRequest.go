@@ -3,22 +3,37 @@ package dex;
 import (
 	"archive/tar"
 	"io"
+	"path"
+	"strings"
 	"polydawn.net/hroot/crocker"
+	"polydawn.net/hroot/util"
 	"polydawn.net/guitar/stream"
-	"sync"
+	"polydawn.net/guitar/conf"
 )
 
+/*
+	A GraphLoadRequest consumes a filesystem loaded from the graph, as a tarstream.
+
+	Graph is in charge of getting the filesystem back out of git (using guitar), so implementations
+	only need to say where the tarstream goes.  Any destination that can take a tar will do;
+	see the variants in this package, or the tarfile package for one that lives outside of dex.
+
+	Graph changes the working directory while it works, so any paths an implementation uses should be absolute.
+*/
 type GraphLoadRequest interface {
-	receive(path string)
+	/*
+		Consumes the filesystem loaded from the graph.
+		The graph considers the load complete when this returns.
+	*/
+	LoadTar(tarstream *tar.Reader)
 }
 
 type GraphLoadRequest_Tar struct {
 	Tarstream *tar.Writer
 }
 
-func (gr *GraphLoadRequest_Tar) receive(path string) {
-	// Use guitar to read the graph contents a tarstream
-	err := stream.ImportFromFilesystem(gr.Tarstream, path)
+func (gr *GraphLoadRequest_Tar) LoadTar(tarstream *tar.Reader) {
+	err := CopyTar(gr.Tarstream, tarstream)
 	if err != nil { panic(err); }
 }
 
@@ -27,27 +42,106 @@ type GraphLoadRequest_Image struct {
 	ImageName string
 }
 
-func (gr *GraphLoadRequest_Image) receive(path string) {
-	//Pipe for I/O, and a waitgroup to make async action blocking
+func (gr *GraphLoadRequest_Image) LoadTar(tarstream *tar.Reader) {
+	// Docker wants tar bytes, so serialize the tarstream back into a pipe
 	importReader, importWriter := io.Pipe()
-	var wait sync.WaitGroup
-	wait.Add(1)
-
-	//Closure to run the docker import
 	go func() {
-		gr.Dock.Import(importReader, gr.ImageName, "latest")
-		wait.Done()
+		tw := tar.NewWriter(importWriter)
+		err := CopyTar(tw, tarstream)
+		if err == nil {
+			err = tw.Close()
+		}
+		importWriter.CloseWithError(err)
 	}()
 
-	//Run the guitar import
-	wat := GraphLoadRequest_Tar{
-		Tarstream: tar.NewWriter(importWriter),
-	} // golang, you're bad.  why can't i one-line this.
-	wat.receive(path);
-	importWriter.Close()
+	name, tag := crocker.SplitImageName(gr.ImageName)
+	gr.Dock.Import(importReader, name, tag)
+}
+
+type GraphLoadRequest_Dir struct {
+	Path string
+}
 
-	// wait for docker importing on the tar byte stream to return
-	wait.Wait()
+func (gr *GraphLoadRequest_Dir) LoadTar(tarstream *tar.Reader) {
+	// Have guitar unpack the tarstream, so it restores the metadata git can't hold (owners, modes, devices)
+	err := stream.ExportToFilesystem(tarstream, gr.Path, conf.Settings{})
+	if err != nil { panic(err); }
+}
+
+/*
+	Takes one file or folder out of a loaded filesystem, writing it to a tarstream under another path.
+	Paths are absolute, as they are in the image; a folder comes with everything in it.
+	Found is set if the filesystem had anything at the path.
+*/
+type GraphLoadRequest_Extract struct {
+	Tarstream *tar.Writer
+	Path string
+	To string
+	Found bool
 }
 
+func (gr *GraphLoadRequest_Extract) LoadTar(tarstream *tar.Reader) {
+	for {
+		hdr, err := tarstream.Next()
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			panic(err)
+		}
 
+		name, ok := gr.rename(hdr.Name)
+		if !ok {
+			continue
+		}
+		gr.Found = true
+
+		if hdr.Typeflag == tar.TypeDir {
+			name += "/"
+		}
+		hdr.Name = name
+		if hdr.Typeflag == tar.TypeLink {
+			// hardlinks name their target by its place in the tar, which moves along with them.
+			// one whose target was skipped has nothing to link to; that's a problem with what was asked for, not a bug.
+			target := hdr.Linkname
+			hdr.Linkname, ok = gr.rename(target)
+			if !ok { util.ExitGently(name, "is a hardlink to", target + ", which is outside of", gr.Path + "; extract a folder that holds both.") }
+		}
+
+		err = gr.Tarstream.WriteHeader(hdr)
+		if err != nil { panic(err); }
+		_, err = io.Copy(gr.Tarstream, tarstream)
+		if err != nil { panic(err); }
+	}
+}
+
+//Gives the new name for an entry, if it's at or under the path being extracted.
+func (gr *GraphLoadRequest_Extract) rename(name string) (string, bool) {
+	// tars name things all sorts of ways ("./a", "a", "/a").
+	name = path.Clean("/"+name)
+	from := path.Clean("/"+gr.Path)
+	if name != from && !strings.HasPrefix(name, strings.TrimSuffix(from, "/")+"/") {
+		return "", false
+	}
+	return strings.TrimPrefix(path.Join(gr.To, strings.TrimPrefix(name, from)), "/"), true
+}
+
+/*
+	Copies every entry in a tarstream to another tarstream.
+	Does not close the destination.
+*/
+func CopyTar(dest *tar.Writer, src *tar.Reader) error {
+	for {
+		hdr, err := src.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		err = dest.WriteHeader(hdr)
+		if err != nil { return err; }
+
+		_, err = io.Copy(dest, src)
+		if err != nil { return err; }
+	}
+}
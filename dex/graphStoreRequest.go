@@ -8,16 +8,26 @@ import (
 	"polydawn.net/guitar/conf"
 )
 
+/*
+	A GraphStoreRequest provides a filesystem for the graph to store, as a tarstream.
+
+	Graph is in charge of laying the filesystem out into git (using guitar), so implementations
+	only need to say where the tarstream comes from.  Any source that can produce a tar will do;
+	see the variants in this package, or the tarfile package for one that lives outside of dex.
+
+	Graph changes the working directory while it works, so any paths an implementation uses should be absolute.
+*/
 type GraphStoreRequest interface {
-	// it's a little hard to decide what goes in here.
-	// i don't *want* to have it talk about writing to a folder on disk, because that's a *current* implementation detail, and one that i actively want to go away.
-	// but that's what it needs to do at the end of the day
-	// unless we leave that detail to Graph and make this focused around tar streams,
-	// which isn't really any less wrong
-	// so really, if at all possible, we should just make the details of what this interface is not actually visible or implementable by others outside of this package.
-	place(path string)
+	/*
+		Returns the filesystem to store.
+		The graph reads the tarstream to the end.
+	*/
+	StoreTar() *tar.Reader
 
-	settings() conf.Settings
+	/*
+		Returns the settings guitar should use when laying the filesystem into the graph.
+	*/
+	StoreSettings() conf.Settings
 }
 
 type GraphStoreRequest_Tar struct {
@@ -25,13 +35,11 @@ type GraphStoreRequest_Tar struct {
 	Settings conf.Settings
 }
 
-func (gr *GraphStoreRequest_Tar) place(path string) {
-	// Use guitar to write the tar's contents to the graph
-	err := stream.ExportToFilesystem(gr.Tarstream, path, gr.Settings)
-	if err != nil { panic(err); }
+func (gr *GraphStoreRequest_Tar) StoreTar() *tar.Reader {
+	return gr.Tarstream
 }
 
-func (gr *GraphStoreRequest_Tar) settings() conf.Settings {
+func (gr *GraphStoreRequest_Tar) StoreSettings() conf.Settings {
 	return gr.Settings
 }
 
@@ -40,21 +48,33 @@ type GraphStoreRequest_Container struct {
 	Settings conf.Settings
 }
 
-func (gr *GraphStoreRequest_Container) place(path string) {
+func (gr *GraphStoreRequest_Container) StoreTar() *tar.Reader {
 	// Ask the container to become a tar byte stream
 	exportReader, exportWriter := io.Pipe()
 	go gr.Container.Export(exportWriter)
 
-	// See it as a tarstream and punt that kind of store request
-	wat := GraphStoreRequest_Tar{
-		Tarstream: tar.NewReader(exportReader),
-		Settings: gr.Settings,
-	} // golang, you're bad.  why can't i one-line this.
-	wat.place(path)
+	return tar.NewReader(exportReader)
 }
 
-func (gr *GraphStoreRequest_Container) settings() conf.Settings {
+func (gr *GraphStoreRequest_Container) StoreSettings() conf.Settings {
 	return gr.Settings
 }
 
+type GraphStoreRequest_Dir struct {
+	Path string
+	Settings conf.Settings
+}
+
+func (gr *GraphStoreRequest_Dir) StoreTar() *tar.Reader {
+	// Ask guitar to read the directory as a tar byte stream
+	importReader, importWriter := io.Pipe()
+	go func() {
+		importWriter.CloseWithError(stream.ImportFromFilesystem(tar.NewWriter(importWriter), gr.Path))
+	}()
 
+	return tar.NewReader(importReader)
+}
+
+func (gr *GraphStoreRequest_Dir) StoreSettings() conf.Settings {
+	return gr.Settings
+}
@@ -5,6 +5,7 @@ package dex
 import (
 	"path/filepath"
 	"bytes"
+	"io/ioutil"
 	"os"
 	"archive/tar"
 	"testing"
@@ -429,3 +430,340 @@ func TestPublishDerivativeExtensionToLineage(t *testing.T) {
 		)
 	})
 }
+
+func tarNames(buf *bytes.Buffer) []string {
+	names := []string{}
+	tr := tar.NewReader(buf)
+	for {
+		hdr, err := tr.Next()
+		if err != nil { break; }
+		names = append(names, hdr.Name)
+	}
+	return names
+}
+
+func TestLoadVersionByHash(t *testing.T) {
+	do(func() {
+		assert := assrt.NewAssert(t)
+
+		g := NewGraph(".")
+		lineage := "line"
+
+		hash1 := g.Publish(
+			lineage,
+			"",
+			&GraphStoreRequest_Tar{
+				Tarstream: fsSetA(),
+			},
+		)
+
+		hash2 := g.Publish(
+			lineage,
+			lineage,
+			&GraphStoreRequest_Tar{
+				Tarstream: fsSetB(),
+			},
+		)
+		assert.NotEqual(hash1, hash2)
+
+		// loading without a version gets the latest
+		var latest bytes.Buffer
+		assert.Equal(
+			hash2,
+			g.Load(lineage, &GraphLoadRequest_Tar{
+				Tarstream: tar.NewWriter(&latest),
+			}),
+		)
+		assert.Equal(
+			[]string{ "a", "d", "d/d", "d/d/z", "e" },
+			tarNames(&latest),
+		)
+
+		// loading by (abbreviated) hash gets the old version
+		var old bytes.Buffer
+		assert.Equal(
+			hash1,
+			g.LoadVersion(lineage, hash1[:7], &GraphLoadRequest_Tar{
+				Tarstream: tar.NewWriter(&old),
+			}),
+		)
+		assert.Equal(
+			[]string{ "a", "b" },
+			tarNames(&old),
+		)
+	})
+}
+
+func TestLoadVersionRejectsOtherLineage(t *testing.T) {
+	do(func() {
+		g := NewGraph(".")
+
+		g.Publish(
+			"line",
+			"",
+			&GraphStoreRequest_Tar{
+				Tarstream: fsSetA(),
+			},
+		)
+		other := g.Publish(
+			"other",
+			"",
+			&GraphStoreRequest_Tar{
+				Tarstream: fsSetB(),
+			},
+		)
+
+		defer func() {
+			err := recover()
+			if err == nil { t.Fail(); }
+		}()
+		g.LoadVersion("line", other, &GraphLoadRequest_Tar{
+			Tarstream: tar.NewWriter(&bytes.Buffer{}),
+		})
+	})
+}
+
+func TestPublishAndLoadDir(t *testing.T) {
+	do(func() {
+		assert := assrt.NewAssert(t)
+
+		g := NewGraph("graph")
+		lineage := "line"
+
+		// lay out a directory on the host
+		os.MkdirAll("host/d", 0755)
+		ioutil.WriteFile("host/a", []byte{ 'a', 'b' }, 0644)
+		ioutil.WriteFile("host/d/z", []byte{ 'z', '\n' }, 0600)
+		hostDir, _ := filepath.Abs("host")
+
+		g.Publish(
+			lineage,
+			"",
+			&GraphStoreRequest_Dir{
+				Path: hostDir,
+			},
+		)
+
+		assert.Equal(
+			1,	// shows the file
+			strings.Count(
+				g.cmd("ls-tree", git_branch_ref_prefix+hroot_image_ref_prefix+lineage, "d/z").Output(),
+				"\n",
+			),
+		)
+
+		// and bring it back out somewhere else
+		outDir, _ := filepath.Abs("out")
+		os.MkdirAll(outDir, 0755)
+		g.Load(
+			lineage,
+			&GraphLoadRequest_Dir{
+				Path: outDir,
+			},
+		)
+
+		content, err := ioutil.ReadFile(filepath.Join(outDir, "d/z"))
+		assert.Nil(err)
+		assert.Equal([]byte{ 'z', '\n' }, content)
+
+		stat, err := os.Stat(filepath.Join(outDir, "d/z"))
+		assert.Nil(err)
+		assert.Equal(os.FileMode(0600), stat.Mode().Perm())
+	})
+}
+
+func TestLoadExtractAndPublishTrailers(t *testing.T) {
+	do(func() {
+		assert := assrt.NewAssert(t)
+
+		g := NewGraph(".")
+		compiler := g.Publish(
+			"compiler",
+			"",
+			&GraphStoreRequest_Tar{
+				Tarstream: fsSetB(),
+			},
+		)
+
+		// only what's under the path comes out, moved to its new place
+		var extracted bytes.Buffer
+		tw := tar.NewWriter(&extracted)
+		gr := &GraphLoadRequest_Extract{
+			Tarstream: tw,
+			Path:      "/d",
+			To:        "/opt/d",
+		}
+		g.LoadVersion("compiler", compiler, gr)
+		tw.Close()
+		assert.True(gr.Found)
+		assert.Equal([]string{ "opt/d/", "opt/d/d/", "opt/d/d/z" }, tarNames(&extracted))
+
+		// asking for something that isn't there finds nothing
+		missing := &GraphLoadRequest_Extract{
+			Tarstream: tar.NewWriter(&bytes.Buffer{}),
+			Path:      "/nope",
+			To:        "/nope",
+		}
+		g.Load("compiler", missing)
+		assert.False(missing.Found)
+
+		// a hardlink to something left behind can't come along
+		var linked bytes.Buffer
+		lw := tar.NewWriter(&linked)
+		lw.WriteHeader(&tar.Header{ Name: "e", Typeflag: tar.TypeReg, Mode: 0644 })
+		lw.WriteHeader(&tar.Header{ Name: "d/e", Typeflag: tar.TypeLink, Linkname: "e" })
+		lw.Close()
+		func() {
+			defer func() {
+				err := recover()
+				if err == nil { t.Fail(); }
+			}()
+			(&GraphLoadRequest_Extract{ Tarstream: tar.NewWriter(&bytes.Buffer{}), Path: "/d", To: "/d" }).LoadTar(tar.NewReader(&linked))
+		}()
+
+		// trailers go on the end of the commit message
+		runtime := g.Publish(
+			"runtime",
+			"",
+			&GraphStoreRequest_Tar{
+				Tarstream: fsSetA(),
+			},
+			"Copied-From: compiler " + compiler,
+		)
+		message := g.cmd("show", "-s", "--format=%B", runtime).Output()
+		assert.True(strings.HasPrefix(message, "runtime imported from an external source\n\n"))
+		assert.True(strings.Contains(message, "Copied-From: compiler " + compiler))
+	})
+}
+
+func TestPublishTaggedVersions(t *testing.T) {
+	do(func() {
+		assert := assrt.NewAssert(t)
+
+		g := NewGraph(".")
+
+		hash1 := g.Publish(
+			"line:1.0",
+			"",
+			&GraphStoreRequest_Tar{
+				Tarstream: fsSetA(),
+			},
+		)
+
+		hash2 := g.Publish(
+			"line:2.0",
+			"line",
+			&GraphStoreRequest_Tar{
+				Tarstream: fsSetB(),
+			},
+		)
+
+		// both versions live on the one lineage, and each tag points at its own
+		assert.Equal(hash2, g.versionOf(git_branch_ref_prefix+hroot_image_ref_prefix+"line"))
+		assert.Equal(hash1, g.versionOf(hroot_tag_ref_prefix+"line/1.0"))
+		assert.Equal(hash2, g.versionOf(hroot_tag_ref_prefix+"line/2.0"))
+
+		// loading by tag gets the tagged version
+		var old bytes.Buffer
+		assert.Equal(
+			hash1,
+			g.Load("line:1.0", &GraphLoadRequest_Tar{
+				Tarstream: tar.NewWriter(&old),
+			}),
+		)
+		assert.Equal(
+			[]string{ "a", "b" },
+			tarNames(&old),
+		)
+
+		// building from a tag parents on the tagged version
+		hash3 := g.Publish(
+			"ferk",
+			"line:1.0",
+			&GraphStoreRequest_Tar{
+				Tarstream: fsSetA2(),
+			},
+		)
+		assert.Equal(
+			hash1 + "\n",
+			g.cmd("rev-parse", hash3+"^1").Output(),
+		)
+	})
+}
+
+func TestTagPromotesVersion(t *testing.T) {
+	do(func() {
+		assert := assrt.NewAssert(t)
+
+		g := NewGraph(".")
+
+		hash1 := g.Publish(
+			"line:rc",
+			"",
+			&GraphStoreRequest_Tar{
+				Tarstream: fsSetA(),
+			},
+		)
+
+		hash2 := g.Publish(
+			"line",
+			"line",
+			&GraphStoreRequest_Tar{
+				Tarstream: fsSetB(),
+			},
+		)
+
+		// tag by copying another tag
+		assert.Equal(hash1, g.Tag("line:rc", "stable", ""))
+		assert.Equal(hash1, g.versionOf(hroot_tag_ref_prefix+"line/stable"))
+
+		// tag by hash moves it
+		assert.Equal(hash2, g.Tag("line", "stable", hash2[:7]))
+		assert.Equal(hash2, g.versionOf(hroot_tag_ref_prefix+"line/stable"))
+
+		// tag the newest version
+		assert.Equal(hash2, g.Tag("line", "newest", ""))
+	})
+}
+
+func TestPullRefusesDivergedLineage(t *testing.T) {
+	do(func() {
+		assert := assrt.NewAssert(t)
+
+		upstream := NewGraph("upstream")
+		upstream.Publish(
+			"line",
+			"",
+			&GraphStoreRequest_Tar{
+				Tarstream: fsSetA(),
+			},
+		)
+
+		g := NewGraph("graph")
+		g.Pull(upstream.dir)
+		mine := g.Publish(
+			"line",
+			"line",
+			&GraphStoreRequest_Tar{
+				Tarstream: fsSetB(),
+			},
+		)
+
+		upstream.Publish(
+			"line",
+			"line",
+			&GraphStoreRequest_Tar{
+				Tarstream: fsSetA2(),
+			},
+		)
+
+		func() {
+			defer func() {
+				err := recover()
+				if err == nil { t.Fail(); }
+			}()
+			g.Pull(upstream.dir)
+		}()
+		assert.Equal(mine, g.versionOf(git_branch_ref_prefix+hroot_image_ref_prefix+"line"))
+	})
+}
@@ -0,0 +1,156 @@
+package dex
+
+import (
+	"bytes"
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"github.com/coocood/assrt"
+	. "polydawn.net/pogo/gosh"
+)
+
+const testKey = "hroot-test@example.com"
+
+/*
+	Makes a throwaway gpg key, and points gpg at it for the duration of fn.
+	Hands fn the path to a keyring holding the public key.
+*/
+func withThrowawayKey(t *testing.T, fn func(keyring string)) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg not available")
+	}
+
+	// gpg-agent sockets don't like long paths, so keep the home short.
+	home, err := ioutil.TempDir("", "hroot-test-gnupg.")
+	if err != nil { panic(err); }
+	defer os.RemoveAll(home)
+	defer Sh("gpgconf")(NullIO)("--homedir", home, "--kill", "gpg-agent")()
+
+	gpg := Sh("gpg")(NullIO)("--batch", "--homedir", home)
+	gpg("--passphrase", "", "--pinentry-mode", "loopback", "--quick-gen-key", testKey, "default", "default", "never")()
+	keyring, _ := filepath.Abs("trusted.gpg")
+	gpg("--output", keyring, "--export", testKey)()
+
+	retreat := os.Getenv("GNUPGHOME")
+	defer os.Setenv("GNUPGHOME", retreat)
+	os.Setenv("GNUPGHOME", home)
+
+	fn(keyring)
+}
+
+func TestSignedPublishVerifiesOnLoad(t *testing.T) {
+	do(func() {
+		withThrowawayKey(t, func(keyring string) {
+			assert := assrt.NewAssert(t)
+
+			g := NewGraph("graph")
+			g.SignWith(testKey)
+			hash := g.Publish(
+				"line",
+				"",
+				&GraphStoreRequest_Tar{
+					Tarstream: fsSetA(),
+				},
+			)
+
+			g.RequireSignatures(keyring)
+			var buf bytes.Buffer
+			assert.Equal(
+				hash,
+				g.Load("line", &GraphLoadRequest_Tar{
+					Tarstream: tar.NewWriter(&buf),
+				}),
+			)
+		})
+	})
+}
+
+func TestUnsignedRejectedOnLoad(t *testing.T) {
+	do(func() {
+		withThrowawayKey(t, func(keyring string) {
+			g := NewGraph("graph")
+			g.Publish(
+				"line",
+				"",
+				&GraphStoreRequest_Tar{
+					Tarstream: fsSetA(),
+				},
+			)
+
+			defer func() {
+				err := recover()
+				if err == nil { t.Fail(); }
+			}()
+			g.RequireSignatures(keyring)
+			g.Load("line", &GraphLoadRequest_Tar{
+				Tarstream: tar.NewWriter(&bytes.Buffer{}),
+			})
+		})
+	})
+}
+
+func TestPullVerifiesSignatures(t *testing.T) {
+	do(func() {
+		withThrowawayKey(t, func(keyring string) {
+			assert := assrt.NewAssert(t)
+
+			upstream := NewGraph("upstream")
+			upstream.SignWith(testKey)
+			hash := upstream.Publish(
+				"line:1.0",
+				"",
+				&GraphStoreRequest_Tar{
+					Tarstream: fsSetA(),
+				},
+			)
+
+			// a signed image comes through, tags and all
+			g := NewGraph("graph")
+			g.RequireSignatures(keyring)
+			g.Pull(upstream.dir, "line")
+			assert.Equal(hash, g.versionOf(git_branch_ref_prefix+hroot_image_ref_prefix+"line"))
+			assert.Equal(hash, g.versionOf(hroot_tag_ref_prefix+"line/1.0"))
+			assert.False(g.hasRef(hroot_pull_ref_prefix+"heads/"+hroot_image_ref_prefix+"line"))
+
+			// an unsigned one does not
+			upstream.SignWith("")
+			upstream.Publish(
+				"line",
+				"line",
+				&GraphStoreRequest_Tar{
+					Tarstream: fsSetB(),
+				},
+			)
+			func() {
+				defer func() {
+					err := recover()
+					if err == nil { t.Fail(); }
+				}()
+				g.Pull(upstream.dir)
+			}()
+			assert.Equal(hash, g.versionOf(git_branch_ref_prefix+hroot_image_ref_prefix+"line"))
+
+			// nor does one hidden under a signed one
+			upstream.SignWith(testKey)
+			upstream.Publish(
+				"line",
+				"line",
+				&GraphStoreRequest_Tar{
+					Tarstream: fsSetA(),
+				},
+			)
+			assert.True(upstream.gitTest("verify-commit", upstream.versionOf(git_branch_ref_prefix+hroot_image_ref_prefix+"line")))
+			func() {
+				defer func() {
+					err := recover()
+					if err == nil { t.Fail(); }
+				}()
+				g.Pull(upstream.dir)
+			}()
+			assert.Equal(hash, g.versionOf(git_branch_ref_prefix+hroot_image_ref_prefix+"line"))
+		})
+	})
+}
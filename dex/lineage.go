@@ -0,0 +1,171 @@
+package dex
+
+import (
+	"fmt"
+	"strings"
+	. "polydawn.net/pogo/gosh"
+	. "polydawn.net/hroot/crocker"
+	"polydawn.net/hroot/util"
+)
+
+/*
+	Starts a new lineage from a version of another image.
+	The new lineage's first version has the same tree as the source, and the source as its one parent.
+	The source can carry a tag, to fork from the tagged version rather than the newest.
+
+	Returns the hash of the new lineage's first version.
+*/
+func (g *Graph) Fork(source string, lineage string) string {
+	sourceLineage, _ := SplitImageName(source)
+	if !g.HasBranch(hroot_image_ref_prefix+sourceLineage) {
+		util.ExitGently("Image branch name", sourceLineage, "not found in graph.")
+	}
+	lineage = g.newLineageName(lineage)
+
+	fmt.Println("Forking", lineage, "from", source)
+	sourceHash := g.versionOf(g.imageRef(source))
+	hash := g.commitTree(g.treeOf(sourceHash), fmt.Sprintf("%s forked from %s", lineage, source), sourceHash)
+	g.cmd("update-ref", git_branch_ref_prefix+hroot_image_ref_prefix+lineage, hash, "")()
+	return hash
+}
+
+/*
+	Renames a lineage.
+	Commits can't be renamed without changing every hash built on them, so this is a fork that takes the old lineage's tags along, followed by a delete of the old lineage.
+	The old lineage's history stays in the graph as the upstream of the new one.
+
+	Returns the hash of the new lineage's first version.
+*/
+func (g *Graph) Rename(lineage string, newName string) string {
+	lineage, tag := SplitImageName(lineage)
+	if tag != DefaultTag {
+		util.ExitGently("Rename a lineage by its name, without a tag.")
+	}
+	if !g.HasBranch(hroot_image_ref_prefix+lineage) {
+		util.ExitGently("Image branch name", lineage, "not found in graph.")
+	}
+	newName = g.newLineageName(newName)
+
+	fmt.Println("Renaming", lineage, "to", newName)
+	oldHash := g.versionOf(git_branch_ref_prefix+hroot_image_ref_prefix+lineage)
+	hash := g.commitTree(g.treeOf(oldHash), fmt.Sprintf("%s renamed from %s", newName, lineage), oldHash)
+	g.cmd("update-ref", git_branch_ref_prefix+hroot_image_ref_prefix+newName, hash, "")()
+
+	// tags still point at the same versions, just under the new name.
+	for _, line := range g.tagsOf(lineage) {
+		g.cmd("update-ref", hroot_tag_ref_prefix+newName+"/"+line[1], line[0], "")()
+		g.cmd("update-ref", "-d", hroot_tag_ref_prefix+lineage+"/"+line[1], line[0])()
+	}
+	g.cmd("update-ref", "-d", git_branch_ref_prefix+hroot_image_ref_prefix+lineage, oldHash)()
+	return hash
+}
+
+/*
+	Deletes a lineage and its tags.
+	Versions other lineages were built from stay in the graph; the rest can be reclaimed by git's garbage collection.
+
+	Refuses if another lineage's newest build was made from this one, since building it again would fail, unless forced.
+	Returns the lineages that still share history with it.
+*/
+func (g *Graph) DeleteLineage(lineage string, force bool) []string {
+	lineage, tag := SplitImageName(lineage)
+	if tag != DefaultTag {
+		util.ExitGently("Delete a lineage by its name, without a tag.")
+	}
+	if !g.HasBranch(hroot_image_ref_prefix+lineage) {
+		util.ExitGently("Image branch name", lineage, "not found in graph.")
+	}
+
+	downstreams := g.Downstreams(lineage)
+	orphans := []string{}
+	for _, downstream := range downstreams {
+		if g.declaredUpstream(downstream) == lineage {
+			orphans = append(orphans, downstream)
+		}
+	}
+	if len(orphans) > 0 && !force {
+		util.ExitGently("Cannot delete", lineage, "- it is the upstream of", strings.Join(orphans, ", "))
+	}
+
+	fmt.Println("Deleting", lineage)
+	for _, line := range g.tagsOf(lineage) {
+		g.cmd("update-ref", "-d", hroot_tag_ref_prefix+lineage+"/"+line[1], line[0])()
+	}
+	g.cmd("update-ref", "-d", git_branch_ref_prefix+hroot_image_ref_prefix+lineage)()
+	return downstreams
+}
+
+/*
+	Lists the other lineages that descend from any version of a lineage, including versions from before it was renamed.
+*/
+func (g *Graph) Downstreams(lineage string) []string {
+	lineage, _ = SplitImageName(lineage)
+	versions := g.versions(lineage)
+	root := versions[len(versions)-1]
+	for {
+		parents, subject := g.commitInfo(root)
+		if !strings.HasPrefix(subject, g.lineageOf(root)+" renamed from ") || len(parents) != 1 {
+			break
+		}
+		versions = g.versionsFrom(parents[0])
+		root = versions[len(versions)-1]
+	}
+
+	downstreams := []string{}
+	for _, ref := range strings.Fields(g.cmd(NullIO)("for-each-ref", "--format=%(refname)", "--contains", root, git_branch_ref_prefix+hroot_image_ref_prefix).Output()) {
+		if name := strings.TrimPrefix(ref, git_branch_ref_prefix+hroot_image_ref_prefix); name != lineage {
+			downstreams = append(downstreams, name)
+		}
+	}
+	return downstreams
+}
+
+/*
+	Returns the lineage another lineage was most recently built from, going by its commit messages; empty if it was imported.
+	Rollbacks and builds from itself are skipped over, and renames are followed back to the old name's upstream.
+*/
+func (g *Graph) declaredUpstream(lineage string) string {
+	versions := g.versions(lineage)
+	for i := 0; i < len(versions); i++ {
+		parents, subject := g.commitInfo(versions[i])
+		if strings.HasPrefix(subject, lineage+" renamed from ") && len(parents) == 1 {
+			lineage = g.lineageOf(parents[0])
+			versions, i = g.versionsFrom(parents[0]), -1
+			continue
+		}
+		source := g.sourceOf(lineage, subject)
+		if strings.HasPrefix(subject, lineage+" updated from ") {
+			source, _ = SplitImageName(strings.TrimPrefix(subject, lineage+" updated from "))
+		}
+		if source != "" && source != lineage {
+			return source
+		}
+	}
+	return ""
+}
+
+//Lists a lineage's tags, as pairs of hash and tag name.
+func (g *Graph) tagsOf(lineage string) [][]string {
+	tags := [][]string{}
+	for _, line := range strings.Split(strings.Trim(g.cmd(NullIO)("for-each-ref", "--format=%(objectname) %(refname)", hroot_tag_ref_prefix+lineage+"/").Output(), "\n"), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			tags = append(tags, []string{ fields[0], strings.TrimPrefix(fields[1], hroot_tag_ref_prefix+lineage+"/") })
+		}
+	}
+	return tags
+}
+
+//Checks a name is free and usable for a new lineage.  Returns it without any tag.
+func (g *Graph) newLineageName(lineage string) string {
+	lineage, tag := SplitImageName(lineage)
+	if tag != DefaultTag {
+		util.ExitGently("A new lineage name can't have a tag.")
+	}
+	if g.HasBranch(hroot_image_ref_prefix+lineage) {
+		util.ExitGently("Image", lineage, "already exists in graph.")
+	}
+	if !g.gitTest("check-ref-format", git_branch_ref_prefix+hroot_image_ref_prefix+lineage) {
+		util.ExitGently("Cannot use", lineage, "as an image name.")
+	}
+	return lineage
+}
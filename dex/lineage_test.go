@@ -0,0 +1,97 @@
+package dex
+
+import (
+	"testing"
+	"github.com/coocood/assrt"
+)
+
+func TestForkLineage(t *testing.T) {
+	do(func() {
+		assert := assrt.NewAssert(t)
+
+		g := NewGraph(".")
+		publishSomeHistory(g)
+		old := g.versions("line")[1]
+		g.Tag("line", "stable", old)
+
+		hash := g.Fork("line:stable", "spoon")
+		assert.Equal([]string{ "ferk", "line", "spoon" }, g.Lineages())
+		assert.Equal(g.treeOf(old), g.treeOf(hash))
+
+		parents, subject := g.commitInfo(hash)
+		assert.Equal([]string{ old }, parents)
+		assert.Equal("spoon forked from line:stable", subject)
+
+		assert.Equal([]string{ "ferk", "spoon" }, g.Downstreams("line"))
+		assert.Equal("line", g.declaredUpstream("spoon"))
+
+		report := g.Verify()
+		assert.Equal(0, report.Problems, report.Messages)
+	})
+}
+
+func TestRenameLineage(t *testing.T) {
+	do(func() {
+		assert := assrt.NewAssert(t)
+
+		g := NewGraph(".")
+		publishSomeHistory(g)
+		old := g.versions("line")
+		g.Tag("line", "stable", old[1])
+
+		hash := g.Rename("line", "example.com/line")
+		assert.Equal([]string{ "example.com/line", "ferk" }, g.Lineages())
+		assert.Equal([]string{ hash }, g.versions("example.com/line"))
+		assert.Equal(g.treeOf(old[0]), g.treeOf(hash))
+		assert.Equal(old[1], g.versionOf(g.imageRef("example.com/line:stable")))
+		assert.False(g.hasRef(hroot_tag_ref_prefix+"line/stable"))
+
+		// the old history is still there, as the upstream of the new name and of ferk.
+		assert.Equal([]string{ "ferk" }, g.Downstreams("example.com/line"))
+		assert.Equal("", g.declaredUpstream("example.com/line"))
+		g.Rename("ferk", "example.com/ferk")
+		assert.Equal("line", g.declaredUpstream("example.com/ferk"))
+		report := g.Verify()
+		assert.Equal(0, report.Problems, report.Messages)
+	})
+}
+
+func TestDeleteLineage(t *testing.T) {
+	do(func() {
+		assert := assrt.NewAssert(t)
+
+		g := NewGraph(".")
+		publishSomeHistory(g)
+		g.Tag("ferk", "stable", "")
+
+		// nothing builds from ferk
+		assert.Equal([]string{}, g.DeleteLineage("ferk", false))
+		assert.Equal([]string{ "line" }, g.Lineages())
+		assert.False(g.hasRef(hroot_tag_ref_prefix+"ferk/stable"))
+	})
+}
+
+func TestDeleteLineageRefusesToOrphan(t *testing.T) {
+	do(func() {
+		assert := assrt.NewAssert(t)
+
+		g := NewGraph(".")
+		publishSomeHistory(g)
+
+		// ferk builds from line
+		assert.Equal("line", g.declaredUpstream("ferk"))
+		func() {
+			defer func() {
+				err := recover()
+				if err == nil { t.Fail(); }
+			}()
+			g.DeleteLineage("line", false)
+		}()
+		assert.Equal([]string{ "ferk", "line" }, g.Lineages())
+
+		assert.Equal([]string{ "ferk" }, g.DeleteLineage("line", true))
+		assert.Equal([]string{ "ferk" }, g.Lineages())
+		report := g.Verify()
+		assert.Equal(0, report.Problems, report.Messages)
+	})
+}
@@ -0,0 +1,210 @@
+package dex
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	. "polydawn.net/pogo/gosh"
+	. "polydawn.net/hroot/crocker"
+	"polydawn.net/hroot/util"
+)
+
+/*
+	Squashes the history of old versions of a lineage, so the disk space they take can be reclaimed.
+
+	The newest keep versions, plus any versions committed since the before date, keep their history.
+	A zero keep or empty before doesn't keep anything by that measure; the newest version is always kept.
+	The before date can be anything git understands, like "2014-06-01" or "3 months ago".
+
+	Kept versions are rewritten with the same trees, messages, and authors, and stay parented on the upstream lineages they were built from.
+	The oldest kept version takes over the upstream of the squashed history, so the lineage still shows where it came from.
+	Tags on kept versions move with them; tags on squashed versions are dropped.
+	Afterwards, git's garbage collection reclaims whatever nothing else refers to.
+
+	Returns the number of versions squashed.
+*/
+func (g *Graph) Prune(lineage string, keep int, before string) int {
+	lineage, _ = SplitImageName(lineage)
+	if !g.HasBranch(hroot_image_ref_prefix+lineage) {
+		util.ExitGently("Image branch name", lineage, "not found in graph.")
+	}
+
+	if keep <= 0 && before == "" {
+		util.ExitGently("Pruning needs to know which versions to keep.")
+	}
+
+	// figure out how many versions make the cut.
+	versions := g.versions(lineage)
+	kept := 1
+	if keep > kept {
+		kept = keep
+	}
+	if before != "" {
+		since := g.parseDate(before)
+		for kept < len(versions) && g.commitTime(versions[kept]) >= since {
+			kept++
+		}
+	}
+	if kept >= len(versions) {
+		fmt.Println("Nothing to prune;", lineage, "has", len(versions), "versions.")
+		return 0
+	}
+	squashed := versions[kept:]
+	fmt.Println("Pruning", lineage + ": keeping", kept, "versions, squashing", len(squashed))
+
+	// other images that were built from the squashed versions will keep them alive.
+	for _, ref := range strings.Fields(g.cmd(NullIO)("for-each-ref", "--format=%(refname)", "--contains", squashed[0], git_branch_ref_prefix+hroot_image_ref_prefix, hroot_tag_ref_prefix).Output()) {
+		if ref != git_branch_ref_prefix+hroot_image_ref_prefix+lineage && !strings.HasPrefix(ref, hroot_tag_ref_prefix+lineage+"/") {
+			fmt.Println("Warning:", ref, "was built from squashed versions, so they can't be reclaimed until it is pruned too.")
+		}
+	}
+
+	isSquashed := map[string]bool{}
+	for _, hash := range squashed {
+		isSquashed[hash] = true
+	}
+
+	// the oldest kept version takes the upstream that's closest to it in the squashed history, if it doesn't have its own.
+	inheritedUpstream := ""
+	for _, hash := range squashed {
+		parents, _ := g.commitInfo(hash)
+		for _, parent := range parents {
+			if g.lineageOf(parent) != lineage {
+				inheritedUpstream = parent
+			}
+		}
+		if inheritedUpstream != "" {
+			break
+		}
+	}
+
+	// rewrite kept versions, oldest first, so each can point at the rewrite of the one before.
+	rewritten := map[string]string{}
+	for i := kept - 1; i >= 0; i-- {
+		hash := versions[i]
+		parents, _ := g.commitInfo(hash)
+		message := g.cmd(NullIO)("show", "-s", "--format=%B", hash).Output()
+
+		newParents := []string{}
+		for _, parent := range parents {
+			if isSquashed[parent] {
+				continue
+			} else if rewrittenParent, ok := rewritten[parent]; ok {
+				parent = rewrittenParent
+			}
+			newParents = append(newParents, parent)
+		}
+
+		if i == kept - 1 {
+			// this is the new start of the lineage's own history; make sure its message still matches its parents.
+			if len(newParents) == 0 && inheritedUpstream != "" {
+				newParents = append(newParents, inheritedUpstream)
+				message = replaceSubject(message, fmt.Sprintf("%s updated from %s", lineage, g.lineageOf(inheritedUpstream)))
+			} else if len(newParents) == 0 {
+				message = replaceSubject(message, fmt.Sprintf("%s imported from an external source", lineage))
+			}
+			message = strings.TrimRight(message, "\n") + fmt.Sprintf("\n\nPruned-History: %d earlier versions\n", len(squashed))
+		}
+
+		rewritten[hash] = g.recommit(hash, newParents, message)
+	}
+
+	// move the lineage and its tags over to the rewritten history.
+	g.cmd("update-ref", git_branch_ref_prefix+hroot_image_ref_prefix+lineage, rewritten[versions[0]], versions[0])()
+	for _, line := range strings.Split(strings.Trim(g.cmd(NullIO)("for-each-ref", "--format=%(objectname) %(refname)", hroot_tag_ref_prefix+lineage+"/").Output(), "\n"), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if rewrittenHash, ok := rewritten[fields[0]]; ok {
+			g.cmd("update-ref", fields[1], rewrittenHash, fields[0])()
+		} else if isSquashed[fields[0]] {
+			fmt.Println("Dropping tag", strings.TrimPrefix(fields[1], hroot_tag_ref_prefix), "from a squashed version.")
+			g.cmd("update-ref", "-d", fields[1], fields[0])()
+		}
+	}
+
+	// reclaim the space.
+	g.cmd("reflog", "expire", "--expire=now", "--all")()
+	g.cmd("gc", "--prune=now", "--quiet")()
+
+	return len(squashed)
+}
+
+//Lists a lineage's own versions, newest first, back to where it was imported or forked from another lineage.
+func (g *Graph) versions(lineage string) []string {
+	return g.versionsFrom(g.versionOf(git_branch_ref_prefix+hroot_image_ref_prefix+lineage))
+}
+
+//Lists the versions of a lineage from the given one back, newest first.  The lineage is the one the given version belongs to.
+func (g *Graph) versionsFrom(hash string) []string {
+	lineage := g.lineageOf(hash)
+	versions := []string{}
+	for hash != "" {
+		versions = append(versions, hash)
+		parents, _ := g.commitInfo(hash)
+
+		// by forceMerge's layout, the previous version is the last parent from this lineage.
+		hash = ""
+		for _, parent := range parents {
+			if g.lineageOf(parent) == lineage {
+				hash = parent
+			}
+		}
+	}
+	return versions
+}
+
+/*
+	Makes a copy of a commit with new parents and message, keeping its tree, author, and dates.
+	The copy is signed if the graph signs commits.
+*/
+func (g *Graph) recommit(hash string, parents []string, message string) string {
+	who := strings.Split(g.cmd(NullIO)("show", "-s", "--date=raw", "--format=%an%x00%ae%x00%ad%x00%cn%x00%ce%x00%cd", hash).Output(), "\x00")
+	commitTreeCmd := g.cmd(
+		"commit-tree", hash+"^{tree}",
+		Opts{In: message},
+		Env{
+			"GIT_AUTHOR_NAME":     who[0],
+			"GIT_AUTHOR_EMAIL":    who[1],
+			"GIT_AUTHOR_DATE":     who[2],
+			"GIT_COMMITTER_NAME":  who[3],
+			"GIT_COMMITTER_EMAIL": who[4],
+			"GIT_COMMITTER_DATE":  strings.TrimRight(who[5], "\n"),
+		},
+	)
+	for _, parent := range parents {
+		commitTreeCmd = commitTreeCmd("-p", parent)
+	}
+	if g.signingKey != "" {
+		commitTreeCmd = commitTreeCmd("-S"+g.signingKey)
+	}
+	return strings.Trim(commitTreeCmd.Output(), "\n")
+}
+
+//Returns a commit's committer timestamp, in seconds since the epoch.
+func (g *Graph) commitTime(hash string) int64 {
+	t, err := strconv.ParseInt(strings.Trim(g.cmd(NullIO)("show", "-s", "--format=%ct", hash).Output(), "\n"), 10, 64)
+	if err != nil { panic(err); }
+	return t
+}
+
+//Parses a date the way git does, into seconds since the epoch.
+func (g *Graph) parseDate(date string) int64 {
+	// rev-parse turns "--since=<date>" into "--max-age=<timestamp>", which is just the parser we want.
+	out := strings.Trim(g.cmd(NullIO)("rev-parse", "--since="+date).Output(), "\n")
+	t, err := strconv.ParseInt(strings.TrimPrefix(out, "--max-age="), 10, 64)
+	if err != nil {
+		util.ExitGently("Could not understand the date", date)
+	}
+	return t
+}
+
+//Replaces the first line of a commit message.
+func replaceSubject(message string, subject string) string {
+	lines := strings.SplitN(message, "\n", 2)
+	if len(lines) == 2 {
+		return subject + "\n" + lines[1]
+	}
+	return subject + "\n"
+}
@@ -0,0 +1,92 @@
+package dex
+
+import (
+	"strings"
+	"testing"
+	"github.com/coocood/assrt"
+)
+
+func TestPruneKeepsNewestVersions(t *testing.T) {
+	do(func() {
+		assert := assrt.NewAssert(t)
+
+		g := NewGraph(".")
+		hashes := []string{}
+		for i, fs := range []func() *GraphStoreRequest_Tar{
+			func() *GraphStoreRequest_Tar { return &GraphStoreRequest_Tar{Tarstream: fsSetA()} },
+			func() *GraphStoreRequest_Tar { return &GraphStoreRequest_Tar{Tarstream: fsSetB()} },
+			func() *GraphStoreRequest_Tar { return &GraphStoreRequest_Tar{Tarstream: fsSetA2()} },
+			func() *GraphStoreRequest_Tar { return &GraphStoreRequest_Tar{Tarstream: fsSetC()} },
+		} {
+			ancestor := "line"
+			if i == 0 { ancestor = ""; }
+			hashes = append(hashes, g.Publish("line", ancestor, fs()))
+		}
+		g.Tag("line", "old", hashes[0])
+		g.Tag("line", "recent", hashes[2])
+		trees := []string{}
+		for _, hash := range hashes {
+			trees = append(trees, g.cmd("rev-parse", hash+"^{tree}").Output())
+		}
+
+		assert.Equal(2, g.Prune("line", 2, ""))
+
+		// two versions left, with the same trees as before
+		versions := g.versions("line")
+		assert.Equal(2, len(versions))
+		assert.Equal(trees[3], g.cmd("rev-parse", versions[0]+"^{tree}").Output())
+		assert.Equal(trees[2], g.cmd("rev-parse", versions[1]+"^{tree}").Output())
+
+		// the new start of the lineage says what happened
+		assert.True(strings.Contains(g.cmd("show", "-s", "--format=%B", versions[1]).Output(), "Pruned-History: 2 earlier versions"))
+
+		// tags followed along, or went away with their versions
+		assert.Equal(versions[1], g.versionOf(hroot_tag_ref_prefix+"line/recent"))
+		assert.False(g.hasRef(hroot_tag_ref_prefix+"line/old"))
+
+		// old versions are really gone, and what's left still checks out
+		assert.False(g.gitTest("cat-file", "-e", hashes[0]))
+		report := g.Verify()
+		assert.Equal(0, report.Problems, report.Messages)
+	})
+}
+
+func TestPruneKeepsUpstream(t *testing.T) {
+	do(func() {
+		assert := assrt.NewAssert(t)
+
+		g := NewGraph(".")
+		publishSomeHistory(g)
+		upstream := g.versions("line")[1]
+
+		// ferk is: forked from line, then updated from line.  squash the fork.
+		assert.Equal(1, g.Prune("ferk", 1, ""))
+
+		versions := g.versions("ferk")
+		assert.Equal(1, len(versions))
+		parents, subject := g.commitInfo(versions[0])
+		assert.Equal("ferk updated from line", subject)
+		assert.Equal(1, len(parents))
+		assert.NotEqual(upstream, parents[0])
+		assert.Equal("line", g.lineageOf(parents[0]))
+
+		report := g.Verify()
+		assert.Equal(0, report.Problems, report.Messages)
+	})
+}
+
+func TestPruneByDate(t *testing.T) {
+	do(func() {
+		assert := assrt.NewAssert(t)
+
+		g := NewGraph(".")
+		publishSomeHistory(g)
+
+		// everything is newer than a week ago, so there's nothing to do
+		assert.Equal(0, g.Prune("line", 0, "1 week ago"))
+
+		// nothing is newer than the far future, so all but the newest go
+		assert.Equal(1, g.Prune("line", 0, "2099-01-01"))
+		assert.Equal(1, len(g.versions("line")))
+	})
+}
@@ -0,0 +1,53 @@
+package dex
+
+import (
+	"fmt"
+	"strings"
+	. "polydawn.net/pogo/gosh"
+	. "polydawn.net/hroot/crocker"
+	"polydawn.net/hroot/util"
+)
+
+/*
+	Rolls a lineage back to an earlier version, by publishing a new version with the same tree as the earlier one.
+
+	History isn't rewritten: the new version's one parent is the current newest version, so anyone who already has the lineage can still fast-forward to it.
+	The commit message names the version rolled back to, and carries the reason as its body.
+	Tags are left where they are.
+
+	Returns the hash of the new version.
+*/
+func (g *Graph) Rollback(image string, version string, reason string) string {
+	lineage, _ := SplitImageName(image)
+	if !g.HasBranch(hroot_image_ref_prefix+lineage) {
+		util.ExitGently("Image branch name", lineage, "not found in graph.")
+	}
+	if strings.TrimSpace(reason) == "" {
+		util.ExitGently("Rolling back needs a reason.")
+	}
+
+	branch := git_branch_ref_prefix+hroot_image_ref_prefix+lineage
+	current := g.versionOf(branch)
+	target := g.resolveVersion(lineage, version)
+	if !g.isAncestor(target, current) {
+		util.ExitGently("Version", version, "is not in the history of", lineage)
+	}
+
+	tree := g.treeOf(target)
+	if tree == g.treeOf(current) {
+		util.ExitGently(lineage, "is already the same as", target)
+	}
+
+	fmt.Println("Rolling back", lineage, "to", target)
+	message := fmt.Sprintf("%s rolled back to %s\n\n%s\n", lineage, target, strings.TrimSpace(reason))
+	hash := g.commitTree(tree, message, current)
+
+	// the old value makes this fail rather than clobber anything published in the meantime.
+	g.cmd("update-ref", branch, hash, current)()
+	return hash
+}
+
+//Returns the hash of a commit's tree.
+func (g *Graph) treeOf(hash string) string {
+	return strings.Trim(g.cmd(NullIO)("rev-parse", hash+"^{tree}").Output(), "\n")
+}
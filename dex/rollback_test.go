@@ -0,0 +1,60 @@
+package dex
+
+import (
+	"archive/tar"
+	"bytes"
+	"strings"
+	"testing"
+	"github.com/coocood/assrt"
+)
+
+func TestRollbackRestoresEarlierVersion(t *testing.T) {
+	do(func() {
+		assert := assrt.NewAssert(t)
+
+		g := NewGraph(".")
+		publishSomeHistory(g)
+		line := g.versions("line")
+		assert.Equal(2, len(line))
+
+		hash := g.Rollback("line", line[1][:7], "the new version broke everything")
+
+		// a new version on top of the old history, with the old tree
+		assert.Equal(hash, g.versionOf(git_branch_ref_prefix+hroot_image_ref_prefix+"line"))
+		assert.Equal(append([]string{ hash }, line...), g.versions("line"))
+		assert.Equal(g.treeOf(line[1]), g.treeOf(hash))
+
+		parents, subject := g.commitInfo(hash)
+		assert.Equal([]string{ line[0] }, parents)
+		assert.Equal("line rolled back to "+line[1], subject)
+		assert.True(strings.Contains(g.cmd("show", "-s", "--format=%B", hash).Output(), "the new version broke everything"))
+
+		var loaded bytes.Buffer
+		g.Load("line", &GraphLoadRequest_Tar{
+			Tarstream: tar.NewWriter(&loaded),
+		})
+		assert.Equal(
+			[]string{ "a", "b" },
+			tarNames(&loaded),
+		)
+
+		report := g.Verify()
+		assert.Equal(0, report.Problems, report.Messages)
+	})
+}
+
+func TestRollbackThenPrune(t *testing.T) {
+	do(func() {
+		assert := assrt.NewAssert(t)
+
+		g := NewGraph(".")
+		publishSomeHistory(g)
+		line := g.versions("line")
+		g.Rollback("line", line[1], "bad build")
+
+		// the version rolled back to gets squashed; the rollback still verifies.
+		assert.Equal(2, g.Prune("line", 1, ""))
+		report := g.Verify("line")
+		assert.Equal(0, report.Problems, report.Messages)
+	})
+}
@@ -0,0 +1,188 @@
+package dex
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"net/http"
+	"net/http/cgi"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	. "polydawn.net/pogo/gosh"
+	. "polydawn.net/hroot/crocker"
+	"polydawn.net/hroot/util"
+)
+
+/*
+	Serves a graph read-only over HTTP.
+
+	Under /git, the graph is a git repository over git's smart HTTP protocol, so another graph can pull from it.
+	Under /api, there's a small JSON API for tools that don't speak git:
+	 - /api/lineages lists every lineage with its newest version and tags,
+	 - /api/versions?image=<name> lists the versions of a lineage, newest first,
+	 - /api/tar?image=<name[:tag]>[&version=<hash>] streams a version's filesystem as a tar.
+*/
+type GraphServer struct {
+	graph *Graph
+
+	git http.Handler
+
+	// Graph changes the working directory while loading, so only one load can happen at a time.
+	mutex sync.Mutex
+}
+
+func NewGraphServer(g *Graph) *GraphServer {
+	git, err := exec.LookPath("git")
+	if err != nil { panic(err); }
+
+	return &GraphServer{
+		graph: g,
+		git: &cgi.Handler{
+			Path: git,
+			Args: []string{ "http-backend" },
+			Root: "/git",
+			Dir: g.dir,
+			Env: []string{
+				"GIT_PROJECT_ROOT="+g.dir,
+				"GIT_HTTP_EXPORT_ALL=1",
+			},
+			InheritEnv: []string{ "PATH" },
+		},
+	}
+}
+
+func (s *GraphServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && !(r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/git-upload-pack")) {
+		http.Error(w, "This graph is read-only.", http.StatusForbidden)
+		return
+	}
+
+	switch {
+		case r.URL.Path == "/git" || strings.HasPrefix(r.URL.Path, "/git/"):
+			if r.URL.Query().Get("service") == "git-receive-pack" {
+				http.Error(w, "This graph is read-only.", http.StatusForbidden)
+				return
+			}
+			s.git.ServeHTTP(w, r)
+		case r.URL.Path == "/api/lineages":
+			s.serveAPI(w, s.lineages)
+		case r.URL.Path == "/api/versions":
+			s.serveAPI(w, func() interface{} {
+				return s.versions(r.URL.Query().Get("image"))
+			})
+		case r.URL.Path == "/api/tar":
+			s.serveTar(w, r.URL.Query().Get("image"), r.URL.Query().Get("version"))
+		default:
+			http.NotFound(w, r)
+	}
+}
+
+type LineageInfo struct {
+	Name    string            `json:"name"`
+	Version string            `json:"version"`
+	Tags    map[string]string `json:"tags"`
+}
+
+type VersionInfo struct {
+	Hash    string    `json:"hash"`
+	Parents []string  `json:"parents"`
+	Message string    `json:"message"`
+	Author  string    `json:"author"`
+	Date    time.Time `json:"date"`
+}
+
+func (s *GraphServer) lineages() interface{} {
+	lineages := []LineageInfo{}
+	for _, lineage := range s.graph.Lineages() {
+		info := LineageInfo{
+			Name: lineage,
+			Version: s.graph.versionOf(git_branch_ref_prefix+hroot_image_ref_prefix+lineage),
+			Tags: map[string]string{},
+		}
+		for _, tag := range s.graph.tagsOf(lineage) {
+			info.Tags[tag[1]] = tag[0]
+		}
+		lineages = append(lineages, info)
+	}
+	return lineages
+}
+
+func (s *GraphServer) versions(image string) interface{} {
+	lineage, _ := SplitImageName(image)
+	if !s.graph.HasLineage(lineage) {
+		util.ExitGently("Image branch name", lineage, "not found in graph.")
+	}
+
+	versions := []VersionInfo{}
+	for _, hash := range s.graph.versions(lineage) {
+		lines := strings.SplitN(s.graph.cmd(NullIO)("show", "-s", "--format=%P%n%an <%ae>%n%at%n%B", hash).Output(), "\n", 4)
+		at, err := strconv.ParseInt(lines[2], 10, 64)
+		if err != nil { panic(err); }
+		versions = append(versions, VersionInfo{
+			Hash: hash,
+			Parents: strings.Fields(lines[0]),
+			Author: lines[1],
+			Date: time.Unix(at, 0).UTC(),
+			Message: strings.TrimRight(lines[3], "\n"),
+		})
+	}
+	return versions
+}
+
+func (s *GraphServer) serveAPI(w http.ResponseWriter, fn func() interface{}) {
+	var v interface{}
+	if !s.guard(w, func() { v = fn() }) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *GraphServer) serveTar(w http.ResponseWriter, image string, version string) {
+	// the version is found before anything is written, so a missing one can still get a proper error.
+	var hash string
+	if !s.guard(w, func() {
+		hash = s.graph.loadableVersion(image, version)
+	}) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	tw := tar.NewWriter(w)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	defer func() {
+		// the client already has a 200 and part of a tar; cutting it off is the only way left to say it's broken.
+		if err := recover(); err != nil {
+			panic(http.ErrAbortHandler)
+		}
+	}()
+	err := s.graph.loadTree(hash, &GraphLoadRequest_Tar{
+		Tarstream: tw,
+	})
+	if err != nil { panic(err); }
+	tw.Close()
+}
+
+/*
+	Runs part of a request with the graph to itself.
+	If the graph exits gently, the message goes back to the client as a 404 and this returns false.
+*/
+func (s *GraphServer) guard(w http.ResponseWriter, fn func()) (ok bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	defer func() {
+		if err := recover(); err != nil {
+			if hrootErr, isHroot := err.(util.HrootError); isHroot {
+				http.Error(w, hrootErr.Error(), http.StatusNotFound)
+			} else {
+				http.Error(w, "Internal error", http.StatusInternalServerError)
+			}
+			ok = false
+		}
+	}()
+	fn()
+	return true
+}
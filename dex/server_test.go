@@ -0,0 +1,85 @@
+package dex
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"github.com/coocood/assrt"
+)
+
+func TestServeForPull(t *testing.T) {
+	do(func() {
+		assert := assrt.NewAssert(t)
+
+		upstream := NewGraph("upstream")
+		publishSomeHistory(upstream)
+		upstream.Tag("line", "stable", upstream.versions("line")[1])
+
+		server := httptest.NewServer(NewGraphServer(upstream))
+		defer server.Close()
+
+		g := NewGraph("graph")
+		g.Pull(server.URL+"/git", "line")
+		assert.Equal([]string{ "line" }, g.Lineages())
+		assert.Equal(upstream.versions("line"), g.versions("line"))
+		assert.Equal(upstream.versionOf(hroot_tag_ref_prefix+"line/stable"), g.versionOf(hroot_tag_ref_prefix+"line/stable"))
+
+		// nobody gets to push.
+		resp, err := http.Get(server.URL+"/git/info/refs?service=git-receive-pack")
+		assert.Nil(err)
+		assert.Equal(http.StatusForbidden, resp.StatusCode)
+	})
+}
+
+func TestServeAPI(t *testing.T) {
+	do(func() {
+		assert := assrt.NewAssert(t)
+
+		g := NewGraph(".")
+		publishSomeHistory(g)
+		old := g.versions("line")[1]
+		g.Tag("line", "stable", old)
+
+		server := httptest.NewServer(NewGraphServer(g))
+		defer server.Close()
+
+		var lineages []LineageInfo
+		resp, err := http.Get(server.URL+"/api/lineages")
+		assert.Nil(err)
+		assert.Nil(json.NewDecoder(resp.Body).Decode(&lineages))
+		assert.Equal(2, len(lineages))
+		assert.Equal("line", lineages[1].Name)
+		assert.Equal(g.versions("line")[0], lineages[1].Version)
+		assert.Equal(map[string]string{ "stable": old }, lineages[1].Tags)
+
+		var versions []VersionInfo
+		resp, err = http.Get(server.URL+"/api/versions?image=line")
+		assert.Nil(err)
+		assert.Nil(json.NewDecoder(resp.Body).Decode(&versions))
+		assert.Equal(2, len(versions))
+		assert.Equal(old, versions[1].Hash)
+		assert.Equal("line imported from an external source", versions[1].Message)
+
+		resp, err = http.Get(server.URL+"/api/tar?image="+url.QueryEscape("line:stable"))
+		assert.Nil(err)
+		var loaded bytes.Buffer
+		loaded.ReadFrom(resp.Body)
+		assert.Equal(
+			[]string{ "a", "b" },
+			tarNames(&loaded),
+		)
+
+		resp, err = http.Get(server.URL+"/api/versions?image=nope")
+		assert.Nil(err)
+		assert.Equal(http.StatusNotFound, resp.StatusCode)
+
+		// a tar that can't be had is an error, not an empty tar
+		resp, err = http.Get(server.URL+"/api/tar?image=line&version=0000000")
+		assert.Nil(err)
+		assert.Equal(http.StatusNotFound, resp.StatusCode)
+		assert.NotEqual("application/x-tar", resp.Header.Get("Content-Type"))
+	})
+}
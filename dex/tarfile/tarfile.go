@@ -0,0 +1,74 @@
+/*
+	Tarfile provides graph store and load requests that read and write tar files on disk.
+
+	It uses nothing but the exported dex interfaces, so it doubles as an example of plugging
+	your own image sources and destinations into a graph from outside the dex package.
+*/
+package tarfile
+
+import (
+	"archive/tar"
+	"os"
+	"polydawn.net/guitar/conf"
+	"polydawn.net/hroot/dex"
+	. "polydawn.net/hroot/util"
+)
+
+// Make sure we stay pluggable.
+var _ dex.GraphStoreRequest = &StoreRequest{}
+var _ dex.GraphLoadRequest = &LoadRequest{}
+
+/*
+	Stores the contents of a tar file into the graph.
+	Path must be absolute.
+*/
+type StoreRequest struct {
+	Path string
+	Settings conf.Settings
+}
+
+func (r *StoreRequest) StoreTar() *tar.Reader {
+	in, err := os.Open(r.Path)
+	if err != nil { ExitGently(err) }
+
+	// The graph reads to the end, and that's our cue to let go of the file.
+	return tar.NewReader(&closeAtEnd{in})
+}
+
+func (r *StoreRequest) StoreSettings() conf.Settings {
+	return r.Settings
+}
+
+/*
+	Loads an image from the graph into a tar file.
+	The file is created, or truncated if it already exists.
+	Path must be absolute.
+*/
+type LoadRequest struct {
+	Path string
+}
+
+func (r *LoadRequest) LoadTar(tarstream *tar.Reader) {
+	out, err := os.OpenFile(r.Path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil { ExitGently(err) }
+	defer out.Close()
+
+	tw := tar.NewWriter(out)
+	err = dex.CopyTar(tw, tarstream)
+	if err != nil { panic(err); }
+	err = tw.Close()
+	if err != nil { panic(err); }
+}
+
+//Closes the file once it has been read to the end (or fails to read).
+type closeAtEnd struct {
+	file *os.File
+}
+
+func (f *closeAtEnd) Read(p []byte) (int, error) {
+	n, err := f.file.Read(p)
+	if err != nil {
+		f.file.Close()
+	}
+	return n, err
+}
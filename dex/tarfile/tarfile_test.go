@@ -0,0 +1,89 @@
+package tarfile
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"github.com/coocood/assrt"
+	"polydawn.net/hroot/dex"
+	"polydawn.net/hroot/testutil"
+)
+
+var do = testutil.Do
+
+func writeTarFile(path string) {
+	out, err := os.Create(path)
+	if err != nil { panic(err); }
+	defer out.Close()
+
+	fs := tar.NewWriter(out)
+	fs.WriteHeader(&tar.Header{
+		Name:     "a",
+		Mode:     0644,
+		Size:     2,
+		Typeflag: tar.TypeReg,
+	})
+	fs.Write([]byte{ 'a', 'b' })
+	fs.WriteHeader(&tar.Header{
+		Name:     "d/z",
+		Mode:     0755,
+		Size:     1,
+		Typeflag: tar.TypeReg,
+	})
+	fs.Write([]byte{ 'z' })
+	fs.Close()
+}
+
+func readTarFile(path string) map[string]string {
+	in, err := os.Open(path)
+	if err != nil { panic(err); }
+	defer in.Close()
+
+	files := map[string]string{}
+	tr := tar.NewReader(in)
+	for {
+		hdr, err := tr.Next()
+		if err != nil { break; }
+		if hdr.Typeflag != tar.TypeReg { continue; }
+		content, _ := ioutil.ReadAll(tr)
+		files[hdr.Name] = string(content)
+	}
+	return files
+}
+
+func TestTarfileRoundTrip(t *testing.T) {
+	do(func() {
+		assert := assrt.NewAssert(t)
+
+		g := dex.NewGraph("graph")
+		writeTarFile("in.tar")
+		in, _ := filepath.Abs("in.tar")
+		out, _ := filepath.Abs("out.tar")
+
+		stored := g.Publish(
+			"line",
+			"",
+			&StoreRequest{
+				Path: in,
+			},
+		)
+
+		loaded := g.Load(
+			"line",
+			&LoadRequest{
+				Path: out,
+			},
+		)
+
+		assert.Equal(stored, loaded)
+		assert.Equal(
+			map[string]string{
+				"a":   "ab",
+				"d/z": "z",
+			},
+			readTarFile(out),
+		)
+	})
+}
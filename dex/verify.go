@@ -0,0 +1,282 @@
+package dex
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	. "polydawn.net/pogo/gosh"
+	. "polydawn.net/hroot/crocker"
+	"polydawn.net/hroot/util"
+)
+
+/*
+	Kinds of problems Verify can find.
+	These are bit flags, so one report can carry several.
+*/
+const (
+	// git objects are missing or damaged.
+	VerifyObjects = 1 << iota
+
+	// the hroot/init marker is missing, so hroot won't recognize the graph.
+	VerifyMarker
+
+	// commits aren't laid out the way Publish makes them.
+	VerifyLayout
+
+	// trees that guitar can't restore into a filesystem.
+	VerifyTree
+)
+
+type VerifyReport struct {
+	// Bitmask of the kinds of problems found; zero if the graph is sound.
+	Problems int
+
+	// One line per problem found.
+	Messages []string
+}
+
+func (r *VerifyReport) problem(kind int, a ...interface{}) {
+	r.Problems |= kind
+	r.Messages = append(r.Messages, strings.TrimRight(fmt.Sprintln(a...), "\n"))
+}
+
+/*
+	Loads a Graph if there is a git repo at the given dir, whether or not it has the hroot/init marker; returns nil if there's no repo.
+	This is for inspecting damaged graphs.  Most things want LoadGraph.
+*/
+func InspectGraph(dir string) *Graph {
+	g := newGraph(dir)
+	if g.isRepoRoot() {
+		return g
+	} else {
+		return nil
+	}
+}
+
+//Lists every lineage in the graph.
+func (g *Graph) Lineages() []string {
+	lineages := []string{}
+	for _, ref := range strings.Fields(g.cmd(NullIO)("for-each-ref", "--format=%(refname)", git_branch_ref_prefix+hroot_image_ref_prefix).Output()) {
+		lineages = append(lineages, strings.TrimPrefix(ref, git_branch_ref_prefix+hroot_image_ref_prefix))
+	}
+	return lineages
+}
+
+/*
+	Checks the given lineages, or every lineage if none are given:
+	 - git objects reachable from them are intact,
+	 - the graph has its hroot/init marker,
+	 - every version's commit is laid out the way forceMerge, Rollback, or Fork makes them (message starting with the lineage name, and the right parents),
+	 - and every version's tree has guitar metadata that matches the files in it.
+*/
+func (g *Graph) Verify(names ...string) *VerifyReport {
+	report := &VerifyReport{}
+
+	if !g.HasBranch(hroot_ref_prefix+"init") {
+		report.problem(VerifyMarker, "Graph is missing its", hroot_ref_prefix+"init", "marker.")
+	}
+
+	// names may come with a version; only the lineage matters here.
+	if len(names) == 0 {
+		names = g.Lineages()
+	}
+	lineages := []string{}
+	tips := []string{}
+	for _, name := range names {
+		lineage, _ := SplitImageName(name)
+		if !g.HasBranch(hroot_image_ref_prefix+lineage) {
+			util.ExitGently("Image branch name", lineage, "not found in graph.")
+		}
+		lineages = append(lineages, lineage)
+		tips = append(tips, git_branch_ref_prefix+hroot_image_ref_prefix+lineage)
+	}
+
+	// check objects first; walking history over damaged objects won't go well.
+	var fsck bytes.Buffer
+	fsckCmd := g.cmd(Opts{Out: &fsck, Err: &fsck})("fsck", "--no-dangling", "--no-progress")
+	for _, tip := range tips {
+		fsckCmd = fsckCmd(tip)
+	}
+	if !succeeds(fsckCmd) {
+		for _, line := range strings.Split(strings.TrimSpace(fsck.String()), "\n") {
+			report.problem(VerifyObjects, line)
+		}
+		return report
+	}
+
+	for _, lineage := range lineages {
+		g.verifyLineage(report, lineage)
+	}
+	return report
+}
+
+/*
+	Walks a lineage's own history, from its newest version back to where it was imported or forked from another lineage.
+*/
+func (g *Graph) verifyLineage(report *VerifyReport, lineage string) {
+	hash := g.versionOf(git_branch_ref_prefix+hroot_image_ref_prefix+lineage)
+	for hash != "" {
+		parents, subject := g.commitInfo(hash)
+		next := ""
+
+		if g.lineageOf(hash) != lineage {
+			report.problem(VerifyLayout, lineage, hash, ": message does not start with the image name:", subject)
+			return
+		}
+		g.verifyTree(report, lineage, hash)
+
+		if subject == lineage+" imported from an external source" {
+			if len(parents) != 0 {
+				report.problem(VerifyLayout, lineage, hash, ": imported version should have no parents, has", len(parents))
+			}
+		} else if strings.HasPrefix(subject, lineage+" updated from ") {
+			source, _ := SplitImageName(strings.TrimPrefix(subject, lineage+" updated from "))
+			switch len(parents) {
+				case 1:
+					// the ancestor and the previous version were one and the same.
+					if g.lineageOf(parents[0]) != source {
+						report.problem(VerifyLayout, lineage, hash, ": parent", parents[0], "is not a version of", source)
+					} else if source == lineage {
+						next = parents[0]
+					}
+				case 2:
+					// first parent is the ancestor, second is the previous version of this lineage.
+					if g.lineageOf(parents[0]) != source {
+						report.problem(VerifyLayout, lineage, hash, ": first parent", parents[0], "is not a version of", source)
+					}
+					if g.lineageOf(parents[1]) != lineage {
+						report.problem(VerifyLayout, lineage, hash, ": second parent", parents[1], "is not a version of", lineage)
+					} else {
+						next = parents[1]
+					}
+				default:
+					report.problem(VerifyLayout, lineage, hash, ": updated version should have one or two parents, has", len(parents))
+			}
+		} else if source := g.sourceOf(lineage, subject); source != "" {
+			// the first version of a forked or renamed lineage: same tree as the source, with it as the one parent.
+			if len(parents) != 1 {
+				report.problem(VerifyLayout, lineage, hash, ": forked version should have one parent, has", len(parents))
+			} else if g.lineageOf(parents[0]) != source {
+				report.problem(VerifyLayout, lineage, hash, ": parent", parents[0], "is not a version of", source)
+			} else if g.treeOf(parents[0]) != g.treeOf(hash) {
+				report.problem(VerifyLayout, lineage, hash, ": forked version does not match its source", parents[0])
+			}
+		} else if strings.HasPrefix(subject, lineage+" rolled back to ") {
+			// one parent, the version it replaced; the version it went back to may since have been pruned.
+			if len(parents) != 1 {
+				report.problem(VerifyLayout, lineage, hash, ": rolled back version should have one parent, has", len(parents))
+			} else if g.lineageOf(parents[0]) != lineage {
+				report.problem(VerifyLayout, lineage, hash, ": parent", parents[0], "is not a version of", lineage)
+			} else {
+				next = parents[0]
+			}
+		} else {
+			report.problem(VerifyLayout, lineage, hash, ": unrecognized commit message:", subject)
+		}
+
+		hash = next
+	}
+}
+
+//Returns the lineage a fork or rename subject names as its source, or empty if it's not one.
+func (g *Graph) sourceOf(lineage string, subject string) string {
+	for _, verb := range []string{ " forked from ", " renamed from " } {
+		if strings.HasPrefix(subject, lineage+verb) {
+			source, _ := SplitImageName(strings.TrimPrefix(subject, lineage+verb))
+			return source
+		}
+	}
+	return ""
+}
+
+//Returns a commit's parent hashes and subject line.
+func (g *Graph) commitInfo(hash string) ([]string, string) {
+	lines := strings.SplitN(g.cmd(NullIO)("show", "-s", "--format=%P%n%s", hash).Output(), "\n", 3)
+	return strings.Fields(lines[0]), lines[1]
+}
+
+//Returns the lineage a commit belongs to: the first word of its message.
+func (g *Graph) lineageOf(hash string) string {
+	_, subject := g.commitInfo(hash)
+	words := strings.Fields(subject)
+	if len(words) == 0 {
+		return ""
+	}
+	return words[0]
+}
+
+/*
+	Checks that guitar can restore a filesystem from a version's tree, by having it read the tree back the way a load does:
+	it must manage to, and what it reads must be what's in the tree, no more and no less.
+*/
+func (g *Graph) verifyTree(report *VerifyReport, lineage string, hash string) {
+	// without its metadata guitar has nothing to go on; say so plainly, rather than however guitar would.
+	if !g.gitTest("cat-file", "-e", hash+":.guitar") {
+		report.problem(VerifyTree, lineage, hash, ": no .guitar metadata")
+		return
+	}
+
+	loaded := &treeNames{ names: map[string]bool{} }
+	if err := g.loadTree(hash, loaded); err != nil {
+		report.problem(VerifyTree, lineage, hash, ": guitar could not read it back:", err)
+		return
+	}
+	if loaded.err != nil {
+		report.problem(VerifyTree, lineage, hash, ": guitar read back a broken tarstream:", loaded.err)
+		return
+	}
+
+	tree := map[string]bool{}
+	for _, name := range strings.Split(g.cmd(NullIO)("ls-tree", "-r", "--name-only", "-z", hash).Output(), "\x00") {
+		if name != "" && name != ".guitar" {
+			tree[name] = true
+		}
+	}
+
+	for _, name := range sortedKeys(loaded.names) {
+		if !tree[name] {
+			report.problem(VerifyTree, lineage, hash, ": guitar restores", name, "but it is not in the tree")
+		}
+	}
+	for _, name := range sortedKeys(tree) {
+		if !loaded.names[name] {
+			report.problem(VerifyTree, lineage, hash, ":", name, "is in the tree but guitar does not restore it")
+		}
+	}
+}
+
+//Collects the names of everything but folders in a loaded filesystem, since those are what git keeps; stops at the first error.
+type treeNames struct {
+	names map[string]bool
+	err   error
+}
+
+func (gr *treeNames) LoadTar(tarstream *tar.Reader) {
+	for {
+		hdr, err := tarstream.Next()
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			gr.err = err
+			return
+		}
+
+		// tars name things all sorts of ways ("./a", "a", "/a"); git doesn't.
+		if hdr.Typeflag != tar.TypeDir {
+			gr.names[strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")] = true
+		}
+	}
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := []string{}
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
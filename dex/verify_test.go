@@ -0,0 +1,131 @@
+package dex
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"github.com/coocood/assrt"
+	. "polydawn.net/pogo/gosh"
+)
+
+func publishSomeHistory(g *Graph) {
+	g.Publish(
+		"line",
+		"",
+		&GraphStoreRequest_Tar{
+			Tarstream: fsSetA(),
+		},
+	)
+	g.Publish(
+		"ferk",
+		"line",
+		&GraphStoreRequest_Tar{
+			Tarstream: fsSetB(),
+		},
+	)
+	g.Publish(
+		"line",
+		"line",
+		&GraphStoreRequest_Tar{
+			Tarstream: fsSetA2(),
+		},
+	)
+	g.Publish(
+		"ferk",
+		"line",
+		&GraphStoreRequest_Tar{
+			Tarstream: fsSetC(),
+		},
+	)
+}
+
+func TestVerifyCleanGraph(t *testing.T) {
+	do(func() {
+		assert := assrt.NewAssert(t)
+
+		g := NewGraph(".")
+		publishSomeHistory(g)
+
+		assert.Equal([]string{ "ferk", "line" }, g.Lineages())
+
+		report := g.Verify()
+		assert.Equal(0, report.Problems, report.Messages)
+
+		report = g.Verify("ferk")
+		assert.Equal(0, report.Problems, report.Messages)
+
+		// a version on the name is ignored, and the caller's names are left alone
+		names := []string{ "line:latest" }
+		report = g.Verify(names...)
+		assert.Equal(0, report.Problems, report.Messages)
+		assert.Equal([]string{ "line:latest" }, names)
+	})
+}
+
+func TestVerifyFindsForeignCommit(t *testing.T) {
+	do(func() {
+		assert := assrt.NewAssert(t)
+
+		g := NewGraph(".")
+		publishSomeHistory(g)
+
+		// someone commits to the lineage by hand
+		tree := g.cmd("rev-parse", git_branch_ref_prefix+hroot_image_ref_prefix+"line^{tree}").Output()
+		hand := g.cmd("commit-tree", tree[:len(tree)-1], "-p", git_branch_ref_prefix+hroot_image_ref_prefix+"line", Opts{In: "fixed a thing"}).Output()
+		g.cmd("update-ref", git_branch_ref_prefix+hroot_image_ref_prefix+"line", hand[:len(hand)-1])()
+
+		report := g.Verify("line")
+		assert.Equal(VerifyLayout, report.Problems, report.Messages)
+	})
+}
+
+func TestVerifyFindsUnrestorableTree(t *testing.T) {
+	do(func() {
+		assert := assrt.NewAssert(t)
+
+		g := NewGraph(".")
+		publishSomeHistory(g)
+
+		// drop the guitar metadata from the newest version, keeping its layout
+		g.withTempTree(func(cmd Command) {
+			g.cmd("checkout", "-f", git_branch_ref_prefix+hroot_image_ref_prefix+"line")()
+			g.cmd("rm", "-q", ".guitar")()
+			g.cmd("symbolic-ref", "HEAD", git_branch_ref_prefix+hroot_image_ref_prefix+"line")()
+			g.forceMerge("line", "line")
+		})
+
+		report := g.Verify("line")
+		assert.Equal(VerifyTree, report.Problems, report.Messages)
+	})
+}
+
+func TestVerifyFindsMissingMarker(t *testing.T) {
+	do(func() {
+		assert := assrt.NewAssert(t)
+
+		g := NewGraph(".")
+		publishSomeHistory(g)
+		g.cmd("update-ref", "-d", git_branch_ref_prefix+hroot_ref_prefix+"init")()
+
+		assert.Nil(LoadGraph("."))
+		report := InspectGraph(".").Verify()
+		assert.Equal(VerifyMarker, report.Problems, report.Messages)
+	})
+}
+
+func TestVerifyFindsMissingObjects(t *testing.T) {
+	do(func() {
+		assert := assrt.NewAssert(t)
+
+		g := NewGraph(".")
+		publishSomeHistory(g)
+
+		// lose a blob out from under the lineage
+		blob := g.cmd("rev-parse", git_branch_ref_prefix+hroot_image_ref_prefix+"ferk:d/z").Output()
+		blob = blob[:len(blob)-1]
+		assert.Nil(os.Remove(filepath.Join(g.dir, "objects", blob[:2], blob[2:])))
+
+		report := g.Verify("ferk")
+		assert.Equal(VerifyObjects, report.Problems, report.Messages)
+	})
+}
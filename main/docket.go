@@ -42,6 +42,12 @@ func panicHandler() {
 func main() {
 	defer panicHandler()
 
+	parser.AddGroup(
+		"Global options",
+		"Options for every command",
+		&GlobalOptions,
+	)
+
 	// parser.AddCommand(
 	// 	"command",
 	// 	"description",
@@ -66,6 +72,156 @@ func main() {
 			Destination: "graph",
 		},
 	)
+	parser.AddCommand(
+		"export",
+		"Export an image to a tar",
+		"Export an image from the graph to a tar file, without using docker.\n\n" +
+			"Usage: hroot export <image>[@hash] <file>",
+		&ExportCmdOpts{},
+	)
+	parser.AddCommand(
+		"import",
+		"Import a tar as an image",
+		"Import a tar file into the graph as a new version of an image, without using docker.\n\n" +
+			"Usage: hroot import <file> <image>",
+		&ImportCmdOpts{},
+	)
+	parser.AddCommand(
+		"checkout",
+		"Check out an image to a directory",
+		"Check out an image from the graph into a directory on the host, without using docker.\n\n" +
+			"Usage: hroot checkout <image>[@hash] <dir>",
+		&CheckoutCmdOpts{},
+	)
+	parser.AddCommand(
+		"commit",
+		"Commit a directory as an image",
+		"Commit a directory on the host into the graph as a new version of an image, without using docker.\n\n" +
+			"Usage: hroot commit <dir> <image>",
+		&CommitCmdOpts{},
+	)
+	parser.AddCommand(
+		"pull",
+		"Pull images from another graph",
+		"Pull images from another graph, by git url, path, or the name of a remote in the [graph] section of hroot.toml.\n" +
+			"Pulls every image unless some are named.\n\n" +
+			"Usage: hroot pull <url|remote> [image...]",
+		&PullCmdOpts{},
+	)
+	parser.AddCommand(
+		"tag",
+		"Tag a version of an image",
+		"Point an image tag at a version of the image in the graph.\n" +
+			"Tags the newest version unless a hash is given; the image may itself name a tag to copy.\n\n" +
+			"Usage: hroot tag <image> <tag> [hash]",
+		&TagCmdOpts{},
+	)
+	parser.AddCommand(
+		"rollback",
+		"Roll an image back to an earlier version",
+		"Publish an earlier version of an image as its newest version.\n" +
+			"History is kept, so anyone who pulls the image gets the rollback too.\n\n" +
+			"Usage: hroot rollback <image> <hash> -m <reason>",
+		&RollbackCmdOpts{},
+	)
+	parser.AddCommand(
+		"config",
+		"Show the configuration for the current directory",
+		"Print the configuration hroot uses in the current directory, after merging every config file it found.\n" +
+			"Each value is marked with the file that set it; lists built up across files show the file for each entry.\n" +
+			"Name a target to see just the settings it runs with.\n\n" +
+			"Usage: hroot config [target] [--json] [--profile name]",
+		&ConfigCmdOpts{},
+	)
+	parser.AddCommand(
+		"check",
+		"Check configuration for mistakes",
+		"Check every config file that applies to the current directory, without running anything.\n" +
+			"Malformed entries are errors; keys hroot doesn't know are warnings. Each is reported with its file and line.",
+		&CheckCmdOpts{},
+	)
+	parser.AddCommand(
+		"serve",
+		"Serve the graph over HTTP",
+		"Serve the graph read-only over HTTP.\n" +
+			"Other graphs can pull from <address>/git; tools that don't speak git can use the JSON API:\n" +
+			"  /api/lineages                             every image, with its newest version and tags\n" +
+			"  /api/versions?image=<image>               the versions of an image, newest first\n" +
+			"  /api/tar?image=<image>[&version=<hash>]   a version of an image, as a tar\n\n" +
+			"Usage: hroot serve [--listen address]",
+		&ServeCmdOpts{},
+	)
+	bundle, _ := parser.AddCommand(
+		"bundle",
+		"Move images between graphs without a network",
+		"Write images to a file, or load them from one, to carry between graphs that can't reach each other.",
+		&BundleCmdOpts{},
+	)
+	bundle.AddCommand(
+		"create",
+		"Write images to a bundle file",
+		"Write images and their tags from the graph to a git bundle file, or every image if none are named.\n" +
+			"With --base, leave out everything the given version already has, for graphs that are only a little behind.\n\n" +
+			"Usage: hroot bundle create <file> [image...] [--base hash]",
+		&BundleCreateCmdOpts{},
+	)
+	bundle.AddCommand(
+		"load",
+		"Load images from a bundle file",
+		"Check a bundle file, then pull its images into the graph.\n\n" +
+			"Usage: hroot bundle load <file>",
+		&BundleLoadCmdOpts{},
+	)
+	lineage, _ := parser.AddCommand(
+		"lineage",
+		"Rename, fork, or delete images in the graph",
+		"Manage the lineages (the branches images are stored on) in the graph.",
+		&LineageCmdOpts{},
+	)
+	lineage.AddCommand(
+		"rename",
+		"Rename an image",
+		"Rename an image and move its tags to the new name.\n" +
+			"The old history is kept as the upstream of the new name.\n\n" +
+			"Usage: hroot lineage rename <image> <new name>",
+		&LineageRenameCmdOpts{},
+	)
+	lineage.AddCommand(
+		"fork",
+		"Start a new image from an existing one",
+		"Start a new image whose first version is a copy of an existing image.\n\n" +
+			"Usage: hroot lineage fork <image> <new name>",
+		&LineageForkCmdOpts{},
+	)
+	lineage.AddCommand(
+		"delete",
+		"Delete an image",
+		"Delete an image and its tags from the graph.\n" +
+			"Refuses if another image is built from it, unless forced.\n\n" +
+			"Usage: hroot lineage delete <image> [--force]",
+		&LineageDeleteCmdOpts{},
+	)
+	parser.AddCommand(
+		"prune",
+		"Squash old history of an image",
+		"Squash the history of old versions of an image, and reclaim the space they took in the graph.\n" +
+			"Prunes the image configured in the current directory unless one is named.\n" +
+			"Defaults come from the [image.prune] section of hroot.toml.\n\n" +
+			"Usage: hroot prune [image] [--keep N] [--before date]",
+		&PruneCmdOpts{},
+	)
+	parser.AddCommand(
+		"verify",
+		"Check the integrity of the graph",
+		"Check the integrity of the given images in the graph, or every image if none are named.\n\n" +
+			"Exits 0 if everything checks out.  Otherwise exits 16, plus:\n" +
+			"   1  if git objects are missing or damaged\n" +
+			"   2  if the graph is missing its hroot/init marker\n" +
+			"   4  if history isn't laid out the way hroot makes it\n" +
+			"   8  if an image's files can't be restored from its tree\n\n" +
+			"Usage: hroot verify [image...]",
+		&VerifyCmdOpts{},
+	)
 	parser.AddCommand(
 		"version",
 		"Print hroot version",
@@ -81,7 +237,8 @@ func main() {
 	}
 
 	//Parse for command & flags, and exit with a relevant return code.
-	_, err := parser.Parse()
+	//Run and build pass what comes after "--" on to the container, so they need to see where it was.
+	_, err := parser.ParseArgs(MarkPassthrough(os.Args[1:], "run", "build"))
 	if err != nil {
 		os.Exit(EXIT_BADARGS)
 	} else {
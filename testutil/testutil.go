@@ -0,0 +1,35 @@
+/*
+	Scaffolding shared by the tests of more than one package.
+*/
+package testutil
+
+import (
+	"io/ioutil"
+	"os"
+)
+
+/*
+	Runs a function in a fresh folder under target/test, then goes back to where it started.
+	The folders are made under $BASEDIR if it's set, and left behind to poke at.
+*/
+func Do(fn func()) {
+	retreat, err := os.Getwd()
+	if err != nil { panic(err); }
+
+	defer os.Chdir(retreat)
+
+	basedir := os.Getenv("BASEDIR")
+	if len(basedir) != 0 {
+		err = os.Chdir(basedir)
+		if err != nil { panic(err); }
+	}
+
+	err = os.MkdirAll("target/test", 0755)
+	if err != nil { panic(err); }
+	tmpdir, err := ioutil.TempDir("target/test","")
+	if err != nil { panic(err); }
+	err = os.Chdir(tmpdir)
+	if err != nil { panic(err); }
+
+	fn()
+}
@@ -29,6 +29,19 @@ func GetTarget(args []string, defaultTarget string) string {
 	}
 }
 
+//Given an image reference, returns the image name and the version hash, if any.
+//	'ubuntu@9d84849' -> 'ubuntu', '9d84849'
+//	'ubuntu' -> 'ubuntu', ''
+func SplitImageHash(image string) (string, string) {
+	sp := strings.SplitN(image, "@", 2)
+
+	if len(sp) == 2 {
+		return sp[0], sp[1]
+	} else {
+		return image, ""
+	}
+}
+
 //Given a URI, return the scheme name separate from everything else
 //	See: https://en.wikipedia.org/wiki/URI_scheme#Generic_syntax
 func ParseURI(input string) (string, string) {
@@ -45,7 +58,8 @@ func ParseURI(input string) (string, string) {
 	//Check that the scheme name is one we support
 	switch scheme {
 		case "docker", "index": //pass
-		case "graph", "file": //sanitize paths
+		case "graph": //may name a configured graph remote, so paths are sanitized once that's ruled out
+		case "file": //sanitize paths
 			path = SanePath(path)
 		case "":
 			ExitGently("Command source/destination is empty; must be one of (graph, file, docker, index)")